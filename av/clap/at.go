@@ -0,0 +1,39 @@
+package clap
+
+import "fmt"
+
+// At is the position of the clap marker in a recording.
+type At int
+
+const (
+	End   At = iota // end-clap found by scanning backward
+	Start           // start-clap or count-in found by scanning forward
+	Both            // both ends are scanned and the better match is used
+)
+
+var atNames = []string{"end", "start", "both"}
+
+// ParseAt parses the clap marker names end, start, both or the alias auto for both.
+func ParseAt(str string) (At, error) {
+	for i, name := range atNames {
+		if str == name {
+			return At(i), nil
+		}
+	}
+	if str == "auto" {
+		return Both, nil
+	}
+	return End, fmt.Errorf("invalid clap marker %q", str)
+}
+
+func (a At) String() string {
+	if a >= 0 && int(a) < len(atNames) {
+		return atNames[a]
+	}
+	return fmt.Sprintf("at(%d)", int(a))
+}
+func (a At) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+func (a *At) UnmarshalText(b []byte) (err error) {
+	*a, err = ParseAt(string(b))
+	return err
+}
@@ -0,0 +1,126 @@
+package clap
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/fft"
+	"github.com/mb0/qnpdub/av/gen"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// BeepSpan is the duration at each end of a waveform searched for a beep.
+var BeepSpan = 60 * av.S
+
+// MinBeep is the minimum normalized correlation of a beep match.
+const MinBeep = 0.1
+
+// matchBeep finds the configured beep at one end of each waveform with a matched filter and
+// returns the results with the beep start as clap offset and the correlation as score.
+// The match score is the sum of the correlations in percent.
+func (d *Detector) matchBeep(at At, ws []pcm.Wave) ([]Clap, int, error) {
+	var max av.Dur
+	var score int
+	res := make([]Clap, 0, len(ws))
+	for _, w := range ws {
+		off, ncc, err := d.FindBeep(w, at)
+		if err != nil {
+			return nil, 0, err
+		}
+		c := Clap{At: at, Clap: d.Format.Dur(off), Score: ncc}
+		if c.Clap > max {
+			max = c.Clap
+		}
+		score += int(ncc * 100)
+		res = append(res, c)
+	}
+	for i, c := range res {
+		res[i].Off = max - c.Clap
+	}
+	return res, score, nil
+}
+
+// FindBeep returns the sample offset of the configured beep in the first or last span of
+// waveform w and the normalized correlation of the match or an error.
+func (d *Detector) FindBeep(w pcm.Wave, at At) (int, float64, error) {
+	wi, err := d.checkWave(w)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := gen.ParseBeep(d.Beep)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, n := 0, wi.Beats(BeepSpan)
+	if n > wi.Count {
+		n = wi.Count
+	}
+	if at == End {
+		start = wi.Count - n
+	}
+	smpls, err := w.ReadSamples(start, n, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read %q: %w", wi.Path, err)
+	}
+	off, ncc := Matched(smpls, b.Template(d.Format.Rate))
+	if ncc < MinBeep {
+		return 0, 0, fmt.Errorf("no %s beep in %q, best correlation %.3f", d.Beep, wi.Path, ncc)
+	}
+	return start + off, ncc, nil
+}
+
+// Matched returns the offset of template tmpl in smpls with the highest absolute normalized
+// correlation and that correlation. The correlation is normalized by the energy of the template
+// and of the samples it covers, so loud sections without the template score low.
+// Inverted recordings match with the same score.
+func Matched(smpls []int16, tmpl []float64) (off int, ncc float64) {
+	m := len(tmpl)
+	if m == 0 || len(smpls) < m {
+		return 0, 0
+	}
+	var et float64
+	for _, v := range tmpl {
+		et += v * v
+	}
+	// prefix sums of the sample energy
+	sqs := make([]float64, len(smpls)+1)
+	for i, s := range smpls {
+		v := float64(s)
+		sqs[i+1] = sqs[i] + v*v
+	}
+	// correlate in overlapping blocks of twice the padded template size
+	size := 2 * fft.Size(m)
+	ft := fft.Real(tmpl, size, nil)
+	for i := range ft {
+		ft[i] = cmplx.Conj(ft[i])
+	}
+	step := size - m + 1
+	buf := make([]complex128, size)
+	for bs := 0; bs+m <= len(smpls); bs += step {
+		for i := range buf {
+			buf[i] = 0
+			if j := bs + i; j < len(smpls) {
+				buf[i] = complex(float64(smpls[j]), 0)
+			}
+		}
+		fft.FFT(buf)
+		for i := range buf {
+			buf[i] *= ft[i]
+		}
+		fft.IFFT(buf)
+		// the first step lags of each block are complete
+		for k := 0; k < step && bs+k+m <= len(smpls); k++ {
+			o := bs + k
+			e := sqs[o+m] - sqs[o]
+			if e <= 0 {
+				continue
+			}
+			if v := math.Abs(real(buf[k])) / math.Sqrt(et*e); v > ncc {
+				off, ncc = o, v
+			}
+		}
+	}
+	return off, ncc
+}
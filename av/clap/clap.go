@@ -5,8 +5,12 @@ import (
 	"os"
 
 	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/dsp"
 	"github.com/mb0/qnpdub/av/ffm"
+	"github.com/mb0/qnpdub/av/fft"
+	"github.com/mb0/qnpdub/av/flux"
 	"github.com/mb0/qnpdub/av/pcm"
+	"github.com/mb0/qnpdub/av/zoom"
 	"github.com/mb0/qnpdub/peak"
 )
 
@@ -17,50 +21,172 @@ var (
 
 // Detector is a helper for clap detection in audio or video files.
 type Detector struct {
-	Format pcm.Format
-	Chunk  int
-	*peak.Detector[int16]
-	bbuf []byte  // byte chunk buf
-	sbuf []int16 // sample chunk buf
+	Config
+	Sel ffm.Sel // audio stream selector
+	Onsets
+	conf  peakConf          // config of the peak detector
+	srcs  map[string]source // media sources by waveform path
+	chain dsp.Chain         // filters applied before onset detection
+	next  int               // byte offset following the last filtered read
+	bbuf  []byte            // byte chunk buf
+	sbuf  []int16           // sample chunk buf
+	pre   []int16           // filter pre-roll buf
+	pbuf  []peak.Peaks[int16]
+	sigs  []peak.Sig[int16]   // signals of the current detection
+	evs   []peak.Event[int16] // events of the current detection
+}
+
+// Onsets is implemented by the onset detectors used for clap detection.
+// It is implemented by the z-score peak detector and the spectral flux detector.
+type Onsets interface {
+	// FeedTo finds the peaks for a chunk of values at an absolute sample offset and stores them
+	// in r. The signals reuse the capacity of r.Sigs.
+	FeedTo(r *peak.Peaks[int16], off int, vals []int16)
+	// Reset reverts the detector to its initial condition for chunks fed forward or backward.
+	Reset(rev bool)
 }
 
 // New returns a new clap detector with the given waveform format and chunk size in bytes.
 func New(f pcm.Format, chunk int) *Detector {
-	// we detect with lag of a quarter chunk, that is 256ms or 2k samples at 8khz.
-	sc := chunk / f.Bytes
-	return &Detector{Format: f, Chunk: chunk,
-		Detector: peak.New[int16](0, 3, sc/4, sc/2),
-		bbuf:     make([]byte, chunk),
-		sbuf:     make([]int16, sc),
-	}
+	c := DefConfig
+	c.Format, c.Chunk = f, chunk
+	return NewConfig(c)
+}
+
+// NewConfig returns a new clap detector with the given config.
+func NewConfig(c Config) *Detector {
+	d := &Detector{Config: c}
+	// an invalid config is reported on first use
+	d.setup()
+	return d
 }
 
 // Default returns a new detector with 8khz-8bit-format at 8k chunk size (16kb, 8kb buffer, 1.024s).
 func Default() *Detector {
-	return New(defFormat, defChunk)
+	return NewConfig(DefConfig)
+}
+
+// setup updates the peak detector and buffers if the config changed.
+// It returns an error for an invalid filter chain and retries on the next call.
+func (d *Detector) setup() error {
+	pc := peakConf{d.Format, d.Chunk, d.Threshold, d.Influence, d.Lag, d.Onset, d.Filter}
+	if d.Onsets != nil && d.conf == pc {
+		return nil
+	}
+	chain, err := dsp.ParseChain(d.Filter, d.Format.Rate)
+	if err != nil {
+		return err
+	}
+	// we detect with lag of a quarter chunk by default, that is 256ms or 2k samples at 8khz.
+	sc := d.Chunk / d.Format.Bytes
+	lag := d.Lag
+	if lag == 0 {
+		lag = sc / 4
+	}
+	if d.Onset == "flux" {
+		// frames of 32ms with 8ms hops at 8khz
+		size := fft.Size(d.Format.Beats(av.S / 32))
+		d.Onsets = flux.New(size, size/4, d.Threshold, av.Max(lag/(size/4), 1))
+	} else {
+		pk := peak.New[int16](d.Influence, d.Threshold, lag, 2*lag)
+		// group the oscillation of a clap into one event
+		pk.Group(d.Format.Beats(av.S / 200))
+		d.Onsets = pk
+	}
+	d.chain = chain
+	d.bbuf = make([]byte, d.Chunk)
+	d.sbuf = make([]int16, sc)
+	d.conf = pc
+	return nil
+}
+
+// peakConf holds the config values used to set up the peak detector.
+type peakConf struct {
+	Format     pcm.Format
+	Chunk      int
+	Trsh, Infl float64
+	Lag        int
+	Onset      string
+	Filter     string
+}
+
+// PreRoll is the duration of samples filtered before a chunk that does not follow the last read.
+// It lets the filter state settle, so the chunk does not start with a step transient.
+var PreRoll = 50 * av.S / 1000
+
+// filter applies the filter chain to the sample buffer read from w at byte offset off.
+// The filter state is reset and pre-rolled if off does not follow the last read.
+func (d *Detector) filter(w pcm.Wave, off int) error {
+	if len(d.chain) == 0 {
+		return nil
+	}
+	if off != d.next {
+		d.chain.Reset()
+		soff := off / d.Format.Bytes
+		if n := av.Min(d.Format.Beats(PreRoll), soff); n > 0 {
+			pre, err := w.ReadSamples(soff-n, n, d.pre[:0])
+			if err != nil {
+				return fmt.Errorf("read %q: %w", w.Stat().Path, err)
+			}
+			for _, v := range pre {
+				d.chain.Process(float64(v))
+			}
+			d.pre = pre
+		}
+	}
+	d.next = off + len(d.sbuf)*d.Format.Bytes
+	d.chain.Apply(d.sbuf)
+	return nil
 }
 
 // Load returns a waveform for the given media file path or an error.
-// It generates the waveform file alongside the media file, if it does not exist.
+// It generates the waveform file and its overview alongside the media file, if it does not exist.
+// A selected audio stream is resolved by probing the media file and added to the waveform name.
 func (d *Detector) Load(path string) (*pcm.File, error) {
 	dest := fmt.Sprintf("%s.%s", path, d.Format.String())
+	spec := "a"
+	if !d.Sel.Zero() {
+		nfo, err := ffm.Probe(path)
+		if err != nil {
+			return nil, err
+		}
+		nfo.ASel = d.Sel
+		if spec = nfo.Spec("audio"); spec == "" {
+			return nil, fmt.Errorf("media %q has no audio stream %s", path, d.Sel)
+		}
+		dest = fmt.Sprintf("%s.%s.%s", path, spec, d.Format.String())
+	}
+	if d.srcs == nil {
+		d.srcs = make(map[string]source)
+	}
+	d.srcs[dest] = source{path, spec}
 	err := d.checkFile(dest, "wavf")
 	if err != nil {
 		err = d.checkFile(path, "media")
 		if err != nil {
 			return nil, err
 		}
-		err = ffm.GenPCMCmd(path, dest, d.Format).Run()
+		err = ffm.GenStreamPCMCmd(path, spec, dest, d.Format).Run()
 		if err != nil {
 			return nil, fmt.Errorf("wavf gen failed: %w", err)
 		}
+		w, err := pcm.Open(dest, d.Format)
+		if err != nil {
+			return nil, err
+		}
+		// generate the display overview alongside the new waveform
+		if _, err = zoom.Load(w); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("zoom gen failed: %w", err)
+		}
+		return w, nil
 	}
 	return pcm.Open(dest, d.Format)
 }
 
 // LoadAll returns a list of waveforms for the given media file path or the first error.
-func (d *Detector) LoadAll(paths ...string) ([]*pcm.File, error) {
-	ws := make([]*pcm.File, 0, len(paths))
+func (d *Detector) LoadAll(paths ...string) ([]pcm.Wave, error) {
+	ws := make([]pcm.Wave, 0, len(paths))
 	for _, path := range paths {
 		w, err := d.Load(path)
 		if err != nil {
@@ -72,15 +198,81 @@ func (d *Detector) LoadAll(paths ...string) ([]*pcm.File, error) {
 }
 
 // Detect returns a list of offsets of significant peaks at the end of w or an error.
-func (d *Detector) Detect(w *pcm.File, n int) ([]int, error) {
-	if w == nil || w.Count == 0 {
-		return nil, fmt.Errorf("empty file")
+func (d *Detector) Detect(w pcm.Wave, n int) ([]int, error) {
+	return d.DetectAt(w, n, End)
+}
+
+// DetectAt returns a list of offsets of significant peaks at the start or end of w or an error.
+// The start is scanned forward and the end backward, so the first offset is closest to that end.
+func (d *Detector) DetectAt(w pcm.Wave, n int, at At) ([]int, error) {
+	pks, err := d.detect(w, n, at)
+	if err != nil {
+		return nil, err
+	}
+	offs := make([]int, 0, len(pks))
+	for _, pk := range pks {
+		ev, _ := d.best(pk)
+		offs = append(offs, ev.Peak)
+	}
+	return offs, nil
+}
+
+// Loudest returns the best ranked event at the configured marker of w as clap, see best.
+// It is used to find the audio clap matching a visual marker. If both ends are configured the
+// better ranked event is used.
+func (d *Detector) Loudest(w pcm.Wave) (Clap, error) {
+	ats := []At{d.At}
+	if d.At == Both {
+		ats = []At{End, Start}
 	}
-	d.Reset()
+	var res Clap
+	var max float64
+	var err error
+	for _, at := range ats {
+		pks, derr := d.detect(w, d.Peaks, at)
+		if derr != nil {
+			err = derr
+			continue
+		}
+		for _, pk := range pks {
+			if ev, r := d.best(pk); res.Conf == nil || r > max {
+				res = Clap{At: at, Clap: d.Format.Dur(ev.Peak), Conf: &Conf{Prom: prom(pk)}}
+				max = r
+			}
+		}
+	}
+	if res.Conf == nil {
+		if err == nil {
+			err = fmt.Errorf("no clap in %q", w.Stat().Path)
+		}
+		return res, err
+	}
+	if d.Refine > 0 {
+		res.Clap, err = d.RefineClap(w, res.Clap)
+	}
+	return res, err
+}
+
+// detect returns up to n chunk peaks with loud events at the start or end of w or an error.
+// The chunks are selected by the rank of their best event, see best.
+// The signals and events of the returned peaks are only valid until the next detection.
+func (d *Detector) detect(w pcm.Wave, n int, at At) ([]peak.Peaks[int16], error) {
+	wi, err := d.checkWave(w)
+	if err != nil {
+		return nil, err
+	}
+	if at != Start && at != End {
+		return nil, fmt.Errorf("invalid clap marker %s", at)
+	}
+	if err = d.setup(); err != nil {
+		return nil, err
+	}
+	d.Reset(at == End)
+	d.sigs, d.evs, d.next = d.sigs[:0], d.evs[:0], -1
 	r := av.NewChunkReader(w, d.bbuf)
-	pro := av.Probe(*r, w.Count*w.Bytes, true)
+	pro := av.Probe(*r, wi.Count*wi.Bytes, at == End)
 	// one chunks give us 0.768s silence data (1.024s - 0.256s warmup lag)
-	c, err := d.readChunks(pro, 1)
+	c, err := d.readChunks(w, pro, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -90,11 +282,11 @@ func (d *Detector) Detect(w *pcm.File, n int) ([]int, error) {
 	if pk := c.Peaks[0]; len(pk.Sigs) > 0 {
 		loud = append(loud, pk)
 	}
-	step := av.Chunks(d.Chunk, w.Bytes*int(w.Beats(5*av.S)))
-	var max int16
+	step := av.Chunks(d.Chunk, wi.Bytes*int(wi.Beats(d.Window)))
+	var max float64
 Probe:
 	for i := 0; i*step < pro.Max; i++ {
-		c, err = d.readChunks(pro, step)
+		c, err = d.readChunks(w, pro, step)
 		if err != nil {
 			return nil, err
 		}
@@ -102,8 +294,8 @@ Probe:
 			if len(pk.Sigs) == 0 {
 				continue
 			}
-			if pk.Max > max {
-				max = pk.Max
+			if _, r := d.best(pk); r > max {
+				max = r
 			}
 			loud = append(loud, pk)
 			if len(loud) > n*3 {
@@ -111,43 +303,91 @@ Probe:
 			}
 		}
 	}
-	offs := make([]int, 0, n)
+	res := make([]peak.Peaks[int16], 0, n)
+	// the rank is proportional to the energy, so we square the loudness ratio
+	cut := max / (d.Loud * d.Loud)
 	for _, pk := range loud {
-		if pk.Max >= max/3 {
-			offs = append(offs, pk.Mao)
-			if len(offs) >= n {
+		if _, r := d.best(pk); r >= cut {
+			res = append(res, pk)
+			if len(res) >= n {
 				break
 			}
 		}
 	}
-	return offs, nil
+	return res, nil
 }
-func (d *Detector) readChunks(pro *av.Prober, n int) (chunks, error) {
-	c := chunks{Peaks: make([]peak.Peaks[int16], 0, n)}
-	err := pro.Next(n, func(off int, buf []byte) error {
-		add := pcm.PCM.Add8
-		if d.Format.Bytes == 2 {
-			add = pcm.PCM.Add16
+
+// best returns the event of pk with the highest rank and the rank.
+// Claps are loud and sharp, so the rank is the event energy divided by the attack time from the
+// first signal to the peak in milliseconds plus one. Without events it returns an event for the
+// max value of pk ranked by its squared deviation.
+func (d *Detector) best(pk peak.Peaks[int16]) (ev peak.Event[int16], rank float64) {
+	if len(pk.Events) == 0 {
+		dev := float64(pk.Max) - pk.Mean
+		ev = peak.Event[int16]{Start: pk.Mao, End: pk.Mao, Peak: pk.Mao, Val: pk.Max, Dev: dev,
+			Pol: 1, Energy: dev * dev, Len: 1}
+		return ev, ev.Energy
+	}
+	ms := float64(av.Max(d.Format.Beats(av.S/1000), 1))
+	for i, e := range pk.Events {
+		if r := e.Energy / (1 + float64(e.Peak-e.Start)/ms); i == 0 || r > rank {
+			ev, rank = e, r
 		}
+	}
+	return ev, rank
+}
+
+// prom returns the prominence of the max value of pk in standard deviations from the mean.
+func prom(pk peak.Peaks[int16]) float64 {
+	if sd := pk.Stdd(); sd > 0 {
+		return (float64(pk.Max) - pk.Mean) / sd
+	}
+	return 0
+}
+func (d *Detector) readChunks(w pcm.Wave, pro *av.Prober, n int) (chunks, error) {
+	c := chunks{Peaks: d.pbuf[:0]}
+	h := func(off int, buf []byte) error {
 		if sn := len(buf) / d.Format.Bytes; cap(d.sbuf) < sn {
 			d.sbuf = make([]int16, sn)
 		}
-		d.sbuf = add(d.Format.PCM, buf, d.sbuf[:0])
-		d.ResetIdx()
+		d.sbuf = d.Format.PCM.Add(buf, d.sbuf[:0])
+		if err := d.filter(w, off); err != nil {
+			return err
+		}
 		soff := off / d.Format.Bytes
 		if c.Off == 0 {
 			c.Off = off / d.Format.Bytes
 		}
-		pk := d.Feed(soff, d.sbuf...)
+		// signals and events are collected in one buffer per detection
+		pk := peak.Peaks[int16]{Sigs: d.sigs[len(d.sigs):], Events: d.evs[len(d.evs):]}
+		d.FeedTo(&pk, soff, d.sbuf)
+		d.sigs = append(d.sigs, pk.Sigs...)
+		d.evs = append(d.evs, pk.Events...)
 		c.Peaks = append(c.Peaks, pk)
 		return nil
-	})
-	if pro.Rev {
-		reverse(c.Peaks)
 	}
+	// we read one chunk at a time, so that backward chunks are fed in probe direction
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		err = pro.Next(1, h)
+	}
+	d.pbuf = c.Peaks
 	return c, err
 }
 
+// checkWave returns the info of w or an error if w is empty, the config is invalid or the format
+// of w differs from the configured format.
+func (d *Detector) checkWave(w pcm.Wave) (pcm.Info, error) {
+	if w == nil || w.Stat().Count == 0 {
+		return pcm.Info{}, fmt.Errorf("empty file")
+	}
+	wi := w.Stat()
+	if wi.Format != d.Format {
+		return wi, fmt.Errorf("waveform %q format %s does not match %s", wi.Path, wi.Format, d.Format)
+	}
+	return wi, d.Check()
+}
+
 func (d *Detector) checkFile(path, typ string) error {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -1,11 +1,21 @@
 package clap
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/gen"
+	"github.com/mb0/qnpdub/av/pcm"
 )
 
 var clapTests = []struct {
@@ -172,3 +182,537 @@ func frameStrs(srcr, dstr av.Rate, offs []int) string {
 	}
 	return b.String()
 }
+
+func TestParseAt(t *testing.T) {
+	for str, want := range map[string]At{"end": End, "start": Start, "both": Both, "auto": Both} {
+		got, err := ParseAt(str)
+		if err != nil || got != want {
+			t.Errorf("parse at %s got %s %v want %s", str, got, err, want)
+		}
+	}
+	if _, err := ParseAt("middle"); err == nil {
+		t.Errorf("parse at middle want error")
+	}
+}
+
+func TestDrift(t *testing.T) {
+	start := []Clap{{Clap: 2 * av.S}, {Clap: 5 * av.S}}
+	end := []Clap{{Clap: 902 * av.S}, {Clap: 905*av.S + av.S/50}}
+	res := []Clap{{}, {}}
+	drift(res, start, end)
+	if res[0].Drift != 0 {
+		t.Errorf("ref drift got %g", res[0].Drift)
+	}
+	if got := res[1].Drift; got < 22.22 || got > 22.23 {
+		t.Errorf("drift got %g want 22.22ppm", got)
+	}
+	if got := res[1].Speed(); got <= 1 {
+		t.Errorf("speed got %g want faster", got)
+	}
+}
+
+func TestMoveClaps(t *testing.T) {
+	// refinement usually moves all claps earlier, the latest clap stays the reference
+	ms := av.S / 1000
+	res := []Clap{{Clap: 5 * av.S, Off: 0}, {Clap: 3 * av.S, Off: 2 * av.S}, {Clap: 4 * av.S, Off: av.S}}
+	moveClaps(res, []av.Dur{5*av.S - 20*ms, 3*av.S - 5*ms, 4*av.S - 30*ms})
+	for i, want := range []av.Dur{0, 2*av.S - 15*ms, av.S + 10*ms} {
+		if got := res[i].Off; got != want {
+			t.Errorf("clap %d off got %s want %s", i, got, want)
+		}
+	}
+}
+
+func TestMatchCands(t *testing.T) {
+	tests := []struct {
+		a, b        []int
+		score, dist int
+		amb         bool
+		cands       []int
+	}{
+		{[]int{4, 7, 15, 20}, []int{4, 8, 16, 21}, 1, 2, false, []int{15, 20, 4}},
+		{[]int{4, 7, 15}, []int{3, 8, 11}, 1, 1, true, []int{11, 8}},
+		{[]int{4, 7, 15, 17}, []int{7, 10, 18, 20}, 1, 3, false, []int{10, 18, 20}},
+	}
+	for _, test := range tests {
+		m := match(DistWeb(test.a), DistWeb(test.b))
+		if m.score != test.score || m.dist != test.dist || m.amb != test.amb {
+			t.Errorf("%v %v got score %d dist %d amb %v want %d %d %v", test.a, test.b,
+				m.score, m.dist, m.amb, test.score, test.dist, test.amb)
+		}
+		if got := m.cands(true); !reflect.DeepEqual(got, test.cands) {
+			t.Errorf("%v %v got cands %v want %v", test.a, test.b, got, test.cands)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	for name := range Presets {
+		c, err := LoadConfig(name)
+		if err != nil || c.Check() != nil {
+			t.Errorf("preset %s got %v %v", name, err, c.Check())
+		}
+	}
+	path := filepath.Join(t.TempDir(), "clap.json")
+	err := os.WriteFile(path, []byte(`{"threshold":2, "peaks":4, "window":"2s", "format":"pcm_s16le_16000"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	want := DefConfig
+	want.Threshold, want.Peaks, want.Window = 2, 4, 2*av.S
+	want.Format = pcm.Format{PCM: pcm.S16LE, Rate: av.Hz(16000)}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("load config got %+v want %+v", c, want)
+	}
+	if _, err := LoadConfig("missing"); err == nil {
+		t.Errorf("load missing config want error")
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	d := Default()
+	d.AddFlags(fs)
+	err = fs.Parse([]string{"-preset=drums", "-peaks=5"})
+	if err != nil || d.Threshold != 4 || d.Peaks != 5 {
+		t.Errorf("flags got %+v %v", d.Config, err)
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	p, err := ParsePattern("1, 1.5,2")
+	if err != nil || p.String() != "0,0.500,1" {
+		t.Errorf("parse pattern got %s %v", p, err)
+	}
+	for _, str := range []string{"0", "0,1,0.5", "0,x"} {
+		if _, err := ParsePattern(str); err == nil {
+			t.Errorf("parse pattern %s want error", str)
+		}
+	}
+}
+
+func TestFindPattern(t *testing.T) {
+	pat := []int{0, 40, 80}
+	ons := []int{100, 139, 181, 300, 330, 345, 400, 440, 480}
+	ms := findPattern(ons, pat, 2, false)
+	want := []pmatch{{400, 0}, {100, 2}}
+	if !reflect.DeepEqual(ms, want) {
+		t.Errorf("find pattern got %v want %v", ms, want)
+	}
+	if ms := findPattern(ons, []int{0, 25}, 2, false); len(ms) != 0 {
+		t.Errorf("find pattern want no match got %v", ms)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	// two recordings with claps at 0, 0.5 and 1s and a drum fill between after the claps
+	d := Default()
+	d.Pattern = Pattern{0, av.S / 2, av.S}
+	ms := av.S / 1000
+	claps := []av.Dur{0, 500 * ms, 1000 * ms, 1600 * ms, 1850 * ms, 2300 * ms, 2450 * ms}
+	ws := []pcm.Wave{
+		synthClaps(d.Format, 20*av.S, 14200*ms, claps...),
+		synthClaps(d.Format, 24*av.S, 15500*ms, claps...),
+	}
+	res, err := d.Match(av.Rate{Num: 25, Den: 1}, ws...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{14.2, 15.5} {
+		if got := res[i].Clap.Val().Seconds(); math.Abs(got-want) > .01 {
+			t.Errorf("clap %d got %.3f want %.3f", i, got, want)
+		}
+	}
+	if got := res[0].Off.Val().Seconds(); math.Abs(got-1.3) > .01 {
+		t.Errorf("offset got %.3f want 1.3", got)
+	}
+}
+
+func TestPlot(t *testing.T) {
+	d := Default()
+	ms := av.S / 1000
+	claps := []av.Dur{0, 1500 * ms, 4000 * ms, 4500 * ms}
+	ws := []pcm.Wave{
+		synthClaps(d.Format, 20*av.S, 14200*ms, claps...),
+		synthClaps(d.Format, 24*av.S, 15500*ms, claps...),
+	}
+	// the tracks show the rows recorded by the chain, reference and pattern matchers
+	for _, mode := range []string{"chain", "ref", "pattern"} {
+		d, rows := Default(), 2
+		switch mode {
+		case "ref":
+			d.Ref = Auto
+		case "pattern":
+			d.Pattern, rows = Pattern(claps), len(claps)-1
+		}
+		res, err := d.Match(av.Rate{Num: 25, Den: 1}, ws...)
+		if err != nil {
+			t.Fatalf("match %s: %v", mode, err)
+		}
+		p, err := d.Plot(ws, res, 400, 80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Tracks) != 2 {
+			t.Fatalf("plot %s tracks got %d want 2", mode, len(p.Tracks))
+		}
+		for i, tr := range p.Tracks {
+			if tr.Clap != res[i].Clap || len(tr.Peaks) == 0 || len(tr.Row) < rows ||
+				!reflect.DeepEqual(tr.Row, res[i].Row) {
+				t.Errorf("plot %s track %d got clap %s peaks %v row %v", mode, i,
+					tr.Clap, tr.Peaks, tr.Row)
+			}
+		}
+	}
+}
+
+func TestMatchSynth(t *testing.T) {
+	// property test with synthetic recordings of the same clap sequence with random lead-ins,
+	// noise levels and music beds. The claps are more than two chunks apart, so that each clap
+	// is detected as separate peak.
+	d := Default()
+	for seed := int64(1); seed <= 20; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		var claps []av.Dur
+		for i, t := 0, av.Dur(0); i < 4; i++ {
+			claps = append(claps, t)
+			t += av.Dur(2100+rnd.Intn(1500)) * av.S / 1000
+		}
+		var leads []av.Dur
+		var ws []pcm.Wave
+		for i := 0; i < 3; i++ {
+			lead := av.Dur(2000+rnd.Intn(20000)) * av.S / 1000
+			tail := av.Dur(1000+rnd.Intn(2000)) * av.S / 1000
+			s := gen.New(d.Format.Rate, lead+claps[3]+tail, seed*10+int64(i))
+			s.Noise(.005 + .02*rnd.Float64())
+			if rnd.Intn(2) == 0 {
+				s.Bed(float64(80+rnd.Intn(80)), .1)
+			}
+			s.Claps(lead, .7+.2*rnd.Float64(), claps...)
+			leads = append(leads, lead)
+			ws = append(ws, s.Wave(d.Format.PCM, fmt.Sprintf("synth%d-%d", seed, i)))
+		}
+		res, err := d.Match(d.Format.Rate, ws...)
+		if err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+			continue
+		}
+		// the clap offsets must match the lead-in differences
+		tol := 2 * av.S / 1000
+		for i := range res {
+			got := res[i].Off - res[0].Off
+			if want := leads[0] - leads[i]; got-want > tol || want-got > tol {
+				t.Errorf("seed %d: offset %d got %s want %s", seed, i, got, want)
+			}
+		}
+	}
+}
+
+func TestMatchBeep(t *testing.T) {
+	// synthetic recordings of a sync beep played over loud music and noise with random lead-ins
+	for _, kind := range []string{"chirp", "mls"} {
+		d := Default()
+		d.Beep = kind
+		b, _ := gen.ParseBeep(kind)
+		for seed := int64(1); seed <= 5; seed++ {
+			rnd := rand.New(rand.NewSource(seed))
+			var leads []av.Dur
+			var ws []pcm.Wave
+			for i := 0; i < 3; i++ {
+				lead := av.Dur(2000+rnd.Intn(20000)) * av.S / 1000
+				s := gen.New(d.Format.Rate, lead+3*av.S, seed*10+int64(i))
+				s.Noise(.02).Bed(float64(80+rnd.Intn(80)), .3)
+				s.Claps(av.S/2, .8, 0, av.S, 2*av.S)
+				s.Beep(b, lead, .15)
+				leads = append(leads, lead)
+				ws = append(ws, s.Wave(d.Format.PCM, fmt.Sprintf("beep%d-%d", seed, i)))
+			}
+			res, err := d.Match(d.Format.Rate, ws...)
+			if err != nil {
+				t.Errorf("%s seed %d: %v", kind, seed, err)
+				continue
+			}
+			tol := d.Format.Dur(1)
+			for i := range res {
+				if got := res[i].Clap; got-leads[i] > tol || leads[i]-got > tol {
+					t.Errorf("%s seed %d: clap %d got %s want %s", kind, seed, i, got, leads[i])
+				}
+				if res[i].Score < MinBeep {
+					t.Errorf("%s seed %d: score %d got %g", kind, seed, i, res[i].Score)
+				}
+			}
+		}
+	}
+	d := Default()
+	d.Beep = "chirp"
+	w := gen.New(d.Format.Rate, 5*av.S, 1).Noise(.1).Wave(d.Format.PCM, "noise")
+	if _, err := d.Match(d.Format.Rate, w, w); err == nil {
+		t.Errorf("want error for noise without beep")
+	}
+}
+
+// synthClaps returns an in-memory waveform of duration dur with quiet noise and claps at start
+// plus each offset.
+func synthClaps(f pcm.Format, dur, start av.Dur, claps ...av.Dur) pcm.Wave {
+	s := gen.New(f.Rate, dur, int64(dur/av.S))
+	s.Noise(.015).Claps(start, .8, claps...)
+	return s.Wave(f.PCM, fmt.Sprintf("claps%s", dur))
+}
+
+func TestScan(t *testing.T) {
+	// silence, a clap at 3s, music until 10s, silence, a clap at 14s and music until 20s
+	d := Default()
+	s := gen.New(d.Format.Rate, 22*av.S, 1).Noise(.015).Claps(0, .8, 3*av.S, 14*av.S)
+	for _, m := range [][2]av.Dur{{3*av.S + av.S/2, 10 * av.S}, {14*av.S + av.S/2, 20 * av.S}} {
+		for i, f := range []float64{220, 277.2, 329.6} {
+			s.Tone(m[0], m[1]-m[0], f, .15-.03*float64(i))
+		}
+	}
+	w := s.Wave(d.Format.PCM, "session")
+	takes, err := d.Scan(w, DefScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(takes) != 2 {
+		t.Fatalf("scan got %d takes %v", len(takes), takes)
+	}
+	for i, want := range []av.Dur{3 * av.S, 14 * av.S} {
+		tk := takes[i]
+		if tk.Clap != want || tk.Start != want-av.S/2 {
+			t.Errorf("take %d got clap %s start %s want %s", i, tk.Clap, tk.Start, want)
+		}
+	}
+	if end := takes[0].End; end < 10*av.S || end > 12*av.S {
+		t.Errorf("take 0 end got %s", end)
+	}
+	if end := takes[1].End; end < 20*av.S || end > 22*av.S {
+		t.Errorf("take 1 end got %s", end)
+	}
+	// a recording that starts with music has no marker before the first silence
+	s = gen.New(d.Format.Rate, 12*av.S, 2).Noise(.015).Claps(0, .8, 5*av.S)
+	copy(s.Smpls, gen.New(d.Format.Rate, 1500*av.S/1000, 3).Bed(97, .9).Smpls)
+	s.Tone(5*av.S+av.S/2, 4*av.S, 220, .15)
+	takes, err = d.Scan(s.Wave(d.Format.PCM, "bed"), DefScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(takes) != 1 || takes[0].Clap != 5*av.S {
+		t.Errorf("scan after bed got takes %v want one at 5s", takes)
+	}
+	d.Filter = "hp:x"
+	if _, err := d.Scan(w, DefScan); err == nil {
+		t.Errorf("scan with invalid filter want error")
+	}
+}
+
+func TestOnset(t *testing.T) {
+	// noise with a clap rising over 20 samples from 1000 to the peak at 1020
+	smpls := make([]int16, 2000)
+	rnd := rand.New(rand.NewSource(2))
+	for i := range smpls {
+		smpls[i] = int16(rnd.Intn(201) - 100)
+	}
+	for i := 0; i < 200; i++ {
+		v := 20000 * (i + 1) / 20
+		if i >= 20 {
+			v = 20000 - 90*i
+		}
+		if i%2 == 1 {
+			v = -v
+		}
+		smpls[1000+i] = int16(v)
+	}
+	if got := Onset(smpls, 48); got != 1000 {
+		t.Errorf("onset got %d want 1000", got)
+	}
+}
+
+func TestLoudestRank(t *testing.T) {
+	// quiet noise with a sharp clap at 4s and a louder tone swelling over 300ms to 7.5s, inside
+	// one detection chunk
+	d := Default()
+	swell := 300 * av.S / 1000
+	w := gen.New(d.Format.Rate, 12*av.S, 3).Noise(.015).Clap(4*av.S, .7).
+		Swell(7500*av.S/1000-swell, swell, 890, .95).Wave(d.Format.PCM, "rank")
+	c, err := d.Loudest(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Clap.Val().Seconds(); math.Abs(got-4) > .01 {
+		t.Errorf("loudest got %.3f want 4", got)
+	}
+}
+
+func TestMatchRef(t *testing.T) {
+	base := []int{20000, 13000, 12000, 8000}
+	shift := func(n int, extra ...int) []int {
+		res := append([]int{}, extra...)
+		for _, o := range base {
+			res = append(res, o+n)
+		}
+		return res
+	}
+	offs := [][]int{
+		shift(0),
+		shift(1000, 30000),
+		shift(5000),
+		{60000, 51000},
+	}
+	d := Default()
+	d.Ref = Auto
+	ws := make([]pcm.Wave, len(offs))
+	webs := make([]Web, len(offs))
+	proms := make([]map[int]float64, len(offs))
+	for i, off := range offs {
+		ws[i] = &pcm.File{Info: pcm.Info{Format: d.Format, Path: fmt.Sprint(i)}}
+		webs[i] = DistWeb(off)
+		proms[i] = map[int]float64{}
+	}
+	res, _, err := d.matchRef(End, ws, webs, proms)
+	var amb *AmbiguousError
+	if err != nil && !errors.As(err, &amb) {
+		t.Fatal(err)
+	}
+	ref := -1
+	for i, c := range res {
+		if c.Ref {
+			ref = i
+		}
+	}
+	if ref < 0 || ref > 2 {
+		t.Fatalf("auto reference got %d", ref)
+	}
+	dur := d.Format.Dur
+	for i, want := range []int{0, 1000, 5000} {
+		if got := res[i].Clap - res[0].Clap; got != dur(want) {
+			t.Errorf("clap %d lag got %s want %s", i, got, dur(want))
+		}
+		if got := res[0].Lags[i]; got != dur(want) {
+			t.Errorf("lag matrix 0 %d got %s want %s", i, got, dur(want))
+		}
+		for _, j := range res[i].Bad {
+			if j != 3 {
+				t.Errorf("clap %d got bad %v", i, res[i].Bad)
+			}
+		}
+	}
+	if bad := res[3].Bad; len(bad) == 0 {
+		t.Errorf("unmatched file want bad pairs got %v", res[3])
+	}
+	d.Ref = 5
+	if _, _, err := d.matchRef(End, ws, webs, proms); err == nil {
+		t.Errorf("invalid reference want error")
+	}
+}
+
+func TestFilterChunks(t *testing.T) {
+	// a filtered backward scan over loud music must not find steps at the chunk starts
+	d := Default()
+	d.Filter = "clap"
+	w := gen.New(d.Format.Rate, 20*av.S, 5).Noise(.01).Bed(97, .5).Wave(d.Format.PCM, "bed")
+	offs, err := d.DetectAt(w, d.Peaks, End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, n := d.Chunk/d.Format.Bytes, w.Stat().Count
+	edge := d.Format.Beats(2 * av.S / 1000)
+	for _, o := range offs {
+		// backward chunks are aligned to the end
+		if rel := ((o-n)%cs + cs) % cs; rel < edge {
+			t.Errorf("signal at %d is %d samples after a chunk start", o, rel)
+		}
+	}
+}
+
+func TestOnsetsMusic(t *testing.T) {
+	// 20s of loud music fading in over 3s with a clap at 12s
+	d := Default()
+	d.Onset = "flux"
+	s := gen.New(d.Format.Rate, 20*av.S, 3).Noise(.015)
+	for i, f := range []float64{220, 330, 440} {
+		s.Swell(0, 3*av.S, f, .24-.04*float64(i)).Tone(3*av.S, 17*av.S, f, .24-.04*float64(i))
+	}
+	w := s.Clap(12*av.S, .9).Wave(d.Format.PCM, "music")
+	for _, at := range []At{End, Start} {
+		d.At = at
+		c, err := d.Loudest(w)
+		if err != nil {
+			t.Fatalf("detect flux %s: %v", at, err)
+		}
+		if got := c.Clap; got < 12*av.S-av.S/50 || got > 12*av.S+av.S/50 {
+			t.Errorf("detect flux %s got %s want 12s", at, got)
+		}
+	}
+}
+
+func TestOnsetsBackward(t *testing.T) {
+	// a backward flux scan must find claps in the last frame of a chunk
+	d := Default()
+	d.Onset, d.At = "flux", End
+	// backward chunks are aligned to the end
+	cs, n := d.Chunk/d.Format.Bytes, d.Format.Beats(20*av.S)
+	for _, back := range []int{20, 100, 180} {
+		clap := d.Format.Dur(n - 10*cs - back)
+		s := gen.New(d.Format.Rate, 20*av.S, 4).Noise(.015)
+		for i, f := range []float64{220, 330, 440} {
+			s.Tone(0, 20*av.S, f, .24-.04*float64(i))
+		}
+		w := s.Clap(clap, .9).Wave(d.Format.PCM, "music")
+		c, err := d.Loudest(w)
+		if err != nil {
+			t.Fatalf("detect flux %d: %v", back, err)
+		}
+		if got := c.Clap; got < clap-av.S/50 || got > clap+av.S/50 {
+			t.Errorf("detect flux %d samples before chunk end got %s want %s", back, got, clap)
+		}
+	}
+}
+
+func TestOnsetsCompare(t *testing.T) {
+	// both onset detectors must find the clap of the example recordings within three frames
+	r := av.Hz(30)
+	for _, test := range clapTests {
+		if _, err := os.Stat(test.path); err != nil {
+			t.Skipf("missing testdata %s", test.path)
+		}
+	}
+	for _, onset := range []string{"zscore", "flux"} {
+		d := Default()
+		d.Onset = onset
+		for _, test := range clapTests {
+			w, err := d.Load(test.path)
+			if err != nil {
+				t.Errorf("load %s: %v", test.path, err)
+				continue
+			}
+			res, err := d.Detect(w, 3)
+			if err != nil {
+				t.Errorf("detect %s %s: %v", onset, test.path, err)
+				continue
+			}
+			want, err := parseFrame(r, test.clap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.Beats(d.Format.Dur(res[0])); got-want > 3 || want-got > 3 {
+				t.Errorf("detect %s %s got %s want %s", onset, test.path,
+					r.FrameStr(d.Format.Dur(res[0])), test.clap)
+			}
+		}
+	}
+}
+
+// parseFrame returns the frame index at rate r for a frame string like 1:18+13.
+func parseFrame(r av.Rate, str string) (int, error) {
+	secs, rest, _ := strings.Cut(str, "+")
+	d, err := av.ParseDur(secs)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid frame %q", str)
+	}
+	return r.Beats(d) + n - 1, nil
+}
@@ -0,0 +1,161 @@
+package clap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/dsp"
+	"github.com/mb0/qnpdub/av/gen"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// Config holds the clap detection parameters.
+type Config struct {
+	Format    pcm.Format `json:"format"`            // waveform format
+	Chunk     int        `json:"chunk"`             // chunk size in bytes
+	Threshold float64    `json:"threshold"`         // peak threshold in standard deviations
+	Influence float64    `json:"influence"`         // influence of peaks on the moving mean
+	Lag       int        `json:"lag"`               // moving mean window in samples or zero for a quarter chunk
+	Peaks     int        `json:"peaks"`             // number of peaks detected for matching
+	Window    av.Dur     `json:"window"`            // search step duration
+	Loud      float64    `json:"loud"`              // loudness cutoff as divisor of the loudest peak
+	At        At         `json:"at"`                // clap marker used for matching
+	Pattern   Pattern    `json:"pattern,omitempty"` // optional clap rhythm to match instead of peak distances
+	Tol       av.Dur     `json:"tol"`               // pattern timing tolerance
+	Refine    av.Dur     `json:"refine,omitempty"`  // onset refinement window at source rate or zero
+	Ref       Ref        `json:"ref,omitempty"`     // chain or reference-based multi-file matching
+	Onset     string     `json:"onset,omitempty"`   // onset detector zscore or flux
+	Filter    string     `json:"filter,omitempty"`  // filter chain spec applied before detection
+	Beep      string     `json:"beep,omitempty"`    // optional sync beep chirp or mls to match instead of claps
+}
+
+// DefConfig is the default config with 8khz-8bit-format at 8k chunk size and no filter chain.
+// The default stays unfiltered, because the clap detection is tuned and tested on the unfiltered
+// waveforms and a high-pass also removes part of the energy of soft claps in quiet rooms, where
+// no low thumps need to be removed. The drums preset enables the clap chain.
+var DefConfig = Config{
+	Format:    defFormat,
+	Chunk:     defChunk,
+	Threshold: 3,
+	Peaks:     8,
+	Window:    5 * av.S,
+	Loud:      3,
+	Tol:       30 * av.S / 1000,
+}
+
+// Presets maps names to config presets for different recording situations.
+var Presets = map[string]Config{
+	"default": DefConfig,
+	// quiet rooms have a low noise floor, so we accept softer claps
+	"quiet": DefConfig.with(func(c *Config) {
+		c.Threshold = 2.5
+		c.Loud = 4
+	}),
+	// loud drum rooms need a higher threshold, claps close to the loudest peak and a high-pass
+	// filter against low thumps
+	"drums": DefConfig.with(func(c *Config) {
+		c.Filter = "clap"
+		c.Threshold = 4
+		c.Influence = 0.2
+		c.Loud = 1.5
+		c.Window = 2 * av.S
+	}),
+}
+
+func (c Config) with(f func(*Config)) Config {
+	f(&c)
+	return c
+}
+
+// LoadConfig returns the preset with name or the config loaded from a json file at name.
+// Fields missing in a json file are set to the default config.
+func LoadConfig(name string) (Config, error) {
+	if c, ok := Presets[name]; ok {
+		return c, nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return DefConfig, fmt.Errorf("clap preset %q not found: %w", name, err)
+	}
+	c := DefConfig
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return DefConfig, fmt.Errorf("clap config %q: %w", name, err)
+	}
+	return c, c.Check()
+}
+
+// Check returns an error if c has invalid values.
+func (c Config) Check() error {
+	switch {
+	case c.Format.Bytes != 1 && c.Format.Bytes != 2 || c.Format.Rate.Num <= 0:
+		return fmt.Errorf("invalid clap format %s", c.Format)
+	case c.Chunk < c.Format.Bytes*16:
+		return fmt.Errorf("invalid clap chunk size %d", c.Chunk)
+	case c.Threshold <= 0:
+		return fmt.Errorf("invalid clap threshold %g", c.Threshold)
+	case c.Influence < 0 || c.Influence > 1:
+		return fmt.Errorf("invalid clap influence %g", c.Influence)
+	case c.Lag < 0 || c.Lag > c.Chunk/c.Format.Bytes:
+		return fmt.Errorf("invalid clap lag %d", c.Lag)
+	case c.Peaks < 1:
+		return fmt.Errorf("invalid clap peak count %d", c.Peaks)
+	case c.Window <= 0:
+		return fmt.Errorf("invalid clap window %s", c.Window)
+	case c.Loud < 1:
+		return fmt.Errorf("invalid clap loudness ratio %g", c.Loud)
+	case c.Onset != "" && c.Onset != "zscore" && c.Onset != "flux":
+		return fmt.Errorf("invalid clap onset detector %q", c.Onset)
+	case c.Ref < Auto:
+		return fmt.Errorf("invalid clap reference %s", c.Ref)
+	case c.Refine < 0:
+		return fmt.Errorf("invalid clap refinement window %s", c.Refine)
+	case len(c.Pattern) > 0 && c.Tol <= 0:
+		return fmt.Errorf("invalid clap pattern tolerance %s", c.Tol)
+	case c.Beep != "" && gen.Beeps[c.Beep].Kind == "":
+		return fmt.Errorf("invalid clap beep %q", c.Beep)
+	}
+	_, err := dsp.ParseChain(c.Filter, c.Format.Rate)
+	return err
+}
+
+// AddFlags adds the config flags to fs. The preset flag replaces the whole config and should
+// be used before the other flags.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.Func("preset", "clap preset "+presetNames()+" or json config file", func(s string) error {
+		pc, err := LoadConfig(s)
+		if err == nil {
+			*c = pc
+		}
+		return err
+	})
+	fs.TextVar(&c.At, "at", c.At, "clap marker at start, end or both")
+	fs.TextVar(&c.Format, "wavf", c.Format, "clap waveform format")
+	fs.Float64Var(&c.Threshold, "threshold", c.Threshold, "clap peak threshold")
+	fs.Float64Var(&c.Influence, "influence", c.Influence, "clap peak influence")
+	fs.IntVar(&c.Lag, "lag", c.Lag, "clap moving mean lag in samples")
+	fs.IntVar(&c.Peaks, "peaks", c.Peaks, "clap peak count")
+	fs.TextVar(&c.Window, "window", c.Window, "clap search window")
+	fs.Float64Var(&c.Loud, "loud", c.Loud, "clap loudness ratio")
+	fs.TextVar(&c.Pattern, "pattern", c.Pattern, "clap pattern times")
+	fs.TextVar(&c.Tol, "tol", c.Tol, "clap pattern tolerance")
+	fs.TextVar(&c.Refine, "refine", c.Refine, "clap onset refinement window")
+	fs.TextVar(&c.Ref, "ref", c.Ref, "clap reference file number, auto or chain")
+	fs.StringVar(&c.Onset, "onset", c.Onset, "clap onset detector zscore or flux")
+	fs.StringVar(&c.Filter, "filter", c.Filter, "clap filter chain")
+	fs.StringVar(&c.Beep, "beep", c.Beep, "clap sync beep chirp or mls")
+}
+
+func presetNames() string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
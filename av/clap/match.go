@@ -2,37 +2,149 @@ package clap
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/mb0/qnpdub/av"
 	"github.com/mb0/qnpdub/av/pcm"
 )
 
+// Clap holds the clap marker, position and sync offset of one waveform.
+// Drift is the clock drift in ppm relative to the first waveform, if claps at both ends matched.
+// Score is the correlation score for results of the cross-correlation aligner and beep matches.
+// Conf and Cands describe the confidence and the runner-up candidates of clap matches.
+// Peaks holds the peaks or onsets used by the match and Row those linked to the clap by a matched
+// distance or pattern offset.
+// With reference-based matching Lags holds the directly matched lag of each waveform relative to
+// this one and Bad the indices of waveforms whose lag disagrees with the lag via the reference.
 type Clap struct {
-	Clap av.Dur `json:"clap"`
-	Off  av.Dur `json:"off,omitempty"`
+	At    At       `json:"at"`
+	Clap  av.Dur   `json:"clap"`
+	Off   av.Dur   `json:"off,omitempty"`
+	Drift float64  `json:"drift,omitempty"`
+	Score float64  `json:"score,omitempty"`
+	Conf  *Conf    `json:"conf,omitempty"`
+	Cands []av.Dur `json:"cands,omitempty"`
+	Peaks []av.Dur `json:"peaks,omitempty"`
+	Row   []av.Dur `json:"row,omitempty"`
+	Ref   bool     `json:"ref,omitempty"`
+	Lags  []av.Dur `json:"lags,omitempty"`
+	Bad   []int    `json:"bad,omitempty"`
 }
 
-// Match detects and matches the end-clapst in the given waveforms and returns as time offset.
-func (d *Detector) Match(rate av.Rate, ws ...*pcm.File) ([]Clap, error) {
+// Conf holds confidence values for a matched clap.
+type Conf struct {
+	Dist int     `json:"dist"` // number of matched peak distances
+	Prom float64 `json:"prom"` // clap peak prominence in standard deviations
+}
+
+// AmbiguousError is returned by Match if the best candidates of a waveform have the same score
+// but different offsets. The results are returned alongside the error for inspection.
+type AmbiguousError struct {
+	Path  string
+	Score int
+	Cands []av.Dur
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("ambiguous clap in %q with score %d for candidates %v", e.Path, e.Score, e.Cands)
+}
+
+// Speed returns the playback speed that compensates the clock drift of c.
+func (c Clap) Speed() float64 { return 1 + c.Drift/1e6 }
+
+// Match detects and matches the claps in the given waveforms and returns as time offset.
+// The results are in the order of the given waveforms.
+// It uses the clap marker configured in d. If both ends are configured the better match is used
+// and the clock drift is measured if the claps at both ends matched.
+// The clap positions are refined at the source sample rate if configured, see RefineClap.
+func (d *Detector) Match(rate av.Rate, ws ...pcm.Wave) ([]Clap, error) {
 	if len(ws) < 2 {
 		return nil, fmt.Errorf("needs at least two waveforms")
 	}
-	const n = 8
+	if d.At != Both {
+		res, _, err := d.matchAt(d.At, ws)
+		if err == nil {
+			err = d.refine(res, ws)
+		}
+		return res, err
+	}
+	end, es, err := d.matchAt(End, ws)
+	start, ss, serr := d.matchAt(Start, ws)
+	if err != nil {
+		if serr != nil {
+			if end != nil {
+				return end, err
+			}
+			return start, serr
+		}
+		return start, d.refine(start, ws)
+	}
+	if serr != nil {
+		return end, d.refine(end, ws)
+	}
+	res := end
+	if ss > es {
+		res = start
+	}
+	drift(res, start, end)
+	return res, d.refine(res, ws)
+}
+
+// drift sets the clock drift in res measured from the span between the start and end claps.
+// A waveform with a longer span than the first has a faster clock and must be played faster.
+func drift(res, start, end []Clap) {
+	ref := end[0].Clap - start[0].Clap
+	if ref <= 0 {
+		return
+	}
+	for i := range res {
+		span := end[i].Clap - start[i].Clap
+		if span <= 0 {
+			continue
+		}
+		res[i].Drift = (float64(span)/float64(ref) - 1) * 1e6
+	}
+}
+
+// matchAt matches the claps at one end of the waveforms and returns the results and match score.
+// It returns the results with an AmbiguousError for the first ambiguous match.
+// A configured beep or clap pattern is matched instead, see matchBeep and matchPattern.
+// The waveforms are matched in a chain or to a reference as configured, see Ref.
+func (d *Detector) matchAt(at At, ws []pcm.Wave) ([]Clap, int, error) {
+	if d.Beep != "" {
+		return d.matchBeep(at, ws)
+	}
+	if len(d.Pattern) > 0 {
+		return d.matchPattern(at, ws)
+	}
+	n := d.Peaks
 	webs := make([]Web, 0, len(ws))
-	var ldex [n][]int // length index
+	proms := make([]map[int]float64, 0, len(ws))
+	ldex := make([][]int, n) // length index
 	for i, w := range ws {
 		// detect n signals from each waveform
-		off, err := d.Detect(w, n)
+		pks, err := d.detect(w, n, at)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		if len(off) < 1 {
-			return nil, fmt.Errorf("sync empty %q", w.Path)
+		if len(pks) < 1 {
+			return nil, 0, fmt.Errorf("sync empty %q", w.Stat().Path)
+		}
+		off := make([]int, 0, len(pks))
+		pm := make(map[int]float64, len(pks))
+		for _, pk := range pks {
+			ev, _ := d.best(pk)
+			off = append(off, ev.Peak)
+			pm[ev.Peak] = prom(pk)
 		}
 		l := len(off) - 1
 		// collect by length and compute dist web
 		ldex[l] = append(ldex[l], i)
 		webs = append(webs, DistWeb(off))
+		proms = append(proms, pm)
+	}
+	if d.Ref != Chain {
+		return d.matchRef(at, ws, webs, proms)
 	}
 	// collect indices from ldex, sorted by length  descending.
 	var hilo []int
@@ -40,26 +152,32 @@ func (d *Detector) Match(rate av.Rate, ws ...*pcm.File) ([]Clap, error) {
 		hilo = append(hilo, ldex[len(ldex)-1-i]...)
 	}
 	// match webs and collect claps and max clap offset
-	var max int
-	claps := make([]int, 0, len(ws))
-	res := make([]Clap, 0, len(ws))
+	var max, score int
+	var amb *AmbiguousError
+	res := make([]Clap, len(ws)) // in input order
 	lst := webs[hilo[0]]
 	for i, idx := range hilo[1:] {
 		cur := webs[idx]
 		m := match(lst, cur)
+		score += m.score
 		if o := m.bc + m.bo; o > max {
 			max = o
 		}
 		ac, bc := m.ac, m.bc
+		acs, bcs := m.cands(false), m.cands(true)
 		if m.rev {
 			ac, bc = m.bc, m.ac
+			acs, bcs = bcs, acs
 		}
 		if i == 0 {
-			claps = append(claps, ac)
-			res = append(res, Clap{Clap: d.Format.Dur(ac)})
+			res[hilo[0]] = d.clap(at, ac, m.dist, proms[hilo[0]], acs)
+			d.rows(&res[hilo[0]], lst.Vals, ac, cur.Vals, bc)
+		}
+		res[idx] = d.clap(at, bc, m.dist, proms[idx], bcs)
+		d.rows(&res[idx], cur.Vals, bc, lst.Vals, ac)
+		if m.amb && amb == nil {
+			amb = &AmbiguousError{Path: ws[idx].Stat().Path, Score: m.score, Cands: res[idx].Cands}
 		}
-		claps = append(claps, bc)
-		res = append(res, Clap{Clap: d.Format.Dur(bc)})
 		lst = cur
 	}
 	// calculate offsets relative to max clap
@@ -69,7 +187,37 @@ func (d *Detector) Match(rate av.Rate, ws ...*pcm.File) ([]Clap, error) {
 		//log.Printf("extra %d", ff)
 		res[i].Off = d.Format.Dur(max) - clap.Clap //  + d.Dur(ff)
 	}
-	return res, nil
+	if amb != nil {
+		return res, score, amb
+	}
+	return res, score, nil
+}
+
+// clap returns a clap result at sample offset off with confidence values and candidates.
+func (d *Detector) clap(at At, off, dist int, proms map[int]float64, cands []int) Clap {
+	c := Clap{At: at, Clap: d.Format.Dur(off), Conf: &Conf{Dist: dist, Prom: proms[off]}}
+	for _, cand := range cands {
+		c.Cands = append(c.Cands, d.Format.Dur(cand))
+	}
+	return c
+}
+
+// rows sets the peaks vals of a clap result c with the clap at off and the row of peaks, whose
+// distance to the clap is also the distance of a peak in other to the other clap oc.
+func (d *Detector) rows(c *Clap, vals []int, off int, other []int, oc int) {
+	c.Peaks, c.Row = c.Peaks[:0], c.Row[:0]
+	for _, v := range vals {
+		c.Peaks = append(c.Peaks, d.Format.Dur(v))
+		if v == off {
+			continue
+		}
+		for _, o := range other {
+			if o != oc && absInt(o-oc) == absInt(v-off) {
+				c.Row = append(c.Row, d.Format.Dur(v))
+				break
+			}
+		}
+	}
 }
 
 func match(a, b Web) (m matcher) {
@@ -98,6 +246,44 @@ type matcher struct {
 	ac, bc int
 	ao, bo int
 	rev    bool
+	score  int
+	dist   int    // number of matched distances of the best rows
+	amb    bool   // whether another candidate has the best score at another offset
+	alts   []cand // runner-up candidates sorted by score and distance count
+}
+
+// cand is a scored clap candidate pair.
+type cand struct {
+	ac, bc int
+	score  int
+	dist   int
+}
+
+// maxCands is the maximum number of runner-up candidates reported.
+const maxCands = 3
+
+// cands returns distinct runner-up offsets in b or in a.
+func (m *matcher) cands(b bool) []int {
+	var res []int
+Outer:
+	for _, c := range m.alts {
+		off, cur := c.ac, m.ac
+		if b {
+			off, cur = c.bc, m.bc
+		}
+		if off == cur {
+			continue
+		}
+		for _, o := range res {
+			if o == off {
+				continue Outer
+			}
+		}
+		if res = append(res, off); len(res) >= maxCands {
+			break
+		}
+	}
+	return res
 }
 
 func (m *matcher) find() {
@@ -107,9 +293,23 @@ func (m *matcher) find() {
 	}
 	max := 0 // we have one default match
 	for i, bc := range m.b.Vals {
-		j, score := matchRows(m.b.Row(i), m.a)
+		br := m.b.Row(i)
+		j, score := matchRows(br, m.a)
+		c := cand{m.a.Vals[j], bc, score, rowDist(br, m.a.Row(j))}
+		m.alts = append(m.alts, c)
 		if score > max {
-			max, m.bc, m.ac = score, bc, m.a.Vals[j]
+			max, m.bc, m.ac, m.dist = score, bc, c.ac, c.dist
+		}
+	}
+	m.score = max
+	sort.SliceStable(m.alts, func(i, j int) bool {
+		a, b := m.alts[i], m.alts[j]
+		return a.score > b.score || a.score == b.score && a.dist > b.dist
+	})
+	// candidates with the same score and distance count at another offset are ambiguous
+	for _, c := range m.alts {
+		if max > 0 && c.score == max && c.dist == m.dist && c.ac-c.bc != m.ac-m.bc {
+			m.amb = true
 		}
 	}
 	m.calcOffs()
@@ -134,6 +334,19 @@ func matchRows(br []int, a Web) (idx, max int) {
 	return idx, max
 }
 
+// rowDist returns the number of distances in row br that are also in row ar.
+func rowDist(br, ar []int) (n int) {
+	for _, bd := range br {
+		for _, ad := range ar {
+			if ad == bd {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
 func (m *matcher) calcOffs() {
 	d := m.ac - m.bc
 	if d < 0 {
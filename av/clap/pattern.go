@@ -0,0 +1,197 @@
+package clap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+	"github.com/mb0/qnpdub/peak"
+)
+
+// Pattern is a clap rhythm as time offsets relative to the first clap.
+type Pattern []av.Dur
+
+// ParsePattern parses a comma separated list of ascending clap times like 0,0.5,1.
+// The times are normalized to start at zero.
+func ParsePattern(str string) (Pattern, error) {
+	if str == "" {
+		return nil, nil
+	}
+	var p Pattern
+	for _, s := range strings.Split(str, ",") {
+		d, err := av.ParseDur(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid clap pattern %q: %w", str, err)
+		}
+		if len(p) > 0 && d <= p[len(p)-1] {
+			return nil, fmt.Errorf("invalid clap pattern %q: times must ascend", str)
+		}
+		p = append(p, d)
+	}
+	if len(p) < 2 {
+		return nil, fmt.Errorf("invalid clap pattern %q: needs at least two claps", str)
+	}
+	for i := len(p) - 1; i >= 0; i-- {
+		p[i] -= p[0]
+	}
+	return p, nil
+}
+
+func (p Pattern) String() string {
+	strs := make([]string, 0, len(p))
+	for _, d := range p {
+		strs = append(strs, d.Secs())
+	}
+	return strings.Join(strs, ",")
+}
+func (p Pattern) MarshalText() ([]byte, error) { return []byte(p.String()), nil }
+func (p *Pattern) UnmarshalText(b []byte) (err error) {
+	*p, err = ParsePattern(string(b))
+	return err
+}
+
+// matchPattern finds the clap pattern at one end of each waveform and returns the results with
+// the first clap of the pattern as clap offset. Only full pattern matches are reported.
+func (d *Detector) matchPattern(at At, ws []pcm.Wave) ([]Clap, int, error) {
+	pat := make([]int, 0, len(d.Pattern))
+	for _, p := range d.Pattern {
+		pat = append(pat, int(d.Format.Beats(p)))
+	}
+	tol := int(d.Format.Beats(d.Tol))
+	var max, score int
+	res := make([]Clap, 0, len(ws))
+	for _, w := range ws {
+		pks, err := d.detect(w, d.Peaks, at)
+		if err != nil {
+			return nil, 0, err
+		}
+		var loud int16
+		for _, pk := range pks {
+			if pk.Max > loud {
+				loud = pk.Max
+			}
+		}
+		ons := onsets(pks, tol, int(float64(loud)/d.Loud))
+		ms := findPattern(ons, pat, tol, at == End)
+		if len(ms) == 0 {
+			return nil, 0, fmt.Errorf("no clap pattern %s in %q", d.Pattern, w.Stat().Path)
+		}
+		off := ms[0].off
+		c := Clap{At: at, Clap: d.Format.Dur(off), Conf: &Conf{Dist: len(pat)}}
+		for _, o := range ons {
+			c.Peaks = append(c.Peaks, d.Format.Dur(o))
+		}
+		for _, p := range pat[1:] {
+			c.Row = append(c.Row, d.Format.Dur(closest(ons, off+p)))
+		}
+		for _, pk := range pks {
+			if off >= pk.Off && off < pk.Off+pk.Len {
+				c.Conf.Prom = prom(pk)
+			}
+		}
+		for _, m := range ms[1:] {
+			if len(c.Cands) >= maxCands {
+				break
+			}
+			c.Cands = append(c.Cands, d.Format.Dur(m.off))
+		}
+		if off > max {
+			max = off
+		}
+		score += len(pat)
+		res = append(res, c)
+	}
+	for i, c := range res {
+		res[i].Off = d.Format.Dur(max) - c.Clap
+	}
+	return res, score, nil
+}
+
+// onsets returns the sorted sample offsets of the loudest signal in each group of signals in pks.
+// Signals less than gap samples apart are grouped and groups quieter than cut are ignored.
+func onsets(pks []peak.Peaks[int16], gap, cut int) []int {
+	var sigs []peak.Sig[int16]
+	for _, pk := range pks {
+		sigs = append(sigs, pk.Sigs...)
+	}
+	// signals of backward chunks are in reverse order
+	sort.Slice(sigs, func(i, j int) bool { return sigs[i].Idx < sigs[j].Idx })
+	var res []int
+	on, top, last := -1, 0, 0
+	flush := func() {
+		if on >= 0 && top >= cut {
+			res = append(res, on)
+		}
+	}
+	for _, s := range sigs {
+		off, v := s.Idx, int(s.Val)
+		if v < 0 {
+			v = -v
+		}
+		if on < 0 || off-last > gap {
+			flush()
+			on, top = off, v
+		} else if v > top {
+			on, top = off, v
+		}
+		last = off
+	}
+	flush()
+	return res
+}
+
+// pmatch is a full pattern match at a sample offset with the summed timing error.
+type pmatch struct {
+	off, err int
+}
+
+// findPattern returns all full matches of the pattern pat in ons within tolerance tol,
+// sorted by timing error. Equal errors prefer matches closer to the end if rev is true.
+func findPattern(ons, pat []int, tol int, rev bool) []pmatch {
+	var res []pmatch
+Onsets:
+	for _, o := range ons {
+		m := pmatch{off: o}
+		for _, p := range pat[1:] {
+			e := nearest(ons, o+p)
+			if e > tol {
+				continue Onsets
+			}
+			m.err += e
+		}
+		res = append(res, m)
+	}
+	if rev {
+		reverse(res)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].err < res[j].err })
+	return res
+}
+
+// nearest returns the distance of the value in vals closest to v.
+func nearest(vals []int, v int) int {
+	min := -1
+	for _, o := range vals {
+		d := o - v
+		if d < 0 {
+			d = -d
+		}
+		if min < 0 || d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// closest returns the value in vals closest to v or v if vals is empty.
+func closest(vals []int, v int) int {
+	res, min := v, -1
+	for _, o := range vals {
+		if d := absInt(o - v); min < 0 || d < min {
+			res, min = o, d
+		}
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package clap
+
+import (
+	"path/filepath"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+	"github.com/mb0/qnpdub/av/plot"
+)
+
+// Plot returns a plot of the waveforms ws around the claps in res for debugging the sync.
+// Each track shows the peaks used by the match, the peaks linked to the clap by a matched distance
+// or pattern offset and the chosen clap as recorded in the results. All tracks show the same span
+// around the clap.
+func (d *Detector) Plot(ws []pcm.Wave, res []Clap, width, height int) (*plot.Plot, error) {
+	span := av.S
+	for _, c := range res {
+		for _, pk := range c.Peaks {
+			s := pk - c.Clap
+			if s < 0 {
+				s = -s
+			}
+			if s += av.S; s > span {
+				span = s
+			}
+		}
+	}
+	p, err := plot.New(width, height, 2*span)
+	if err != nil {
+		return nil, err
+	}
+	for i, w := range ws {
+		tr, err := p.Add(filepath.Base(w.Stat().Path), w, res[i].Clap-span)
+		if err != nil {
+			return nil, err
+		}
+		tr.Clap, tr.Peaks, tr.Row = res[i].Clap, res[i].Peaks, res[i].Row
+	}
+	return p, nil
+}
@@ -0,0 +1,171 @@
+package clap
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// Ref selects how multiple waveforms are matched.
+// Chain matches neighbours in a chain sorted by peak count, Auto matches all waveforms to the
+// best reference and a positive value is the number of the reference starting at one.
+type Ref int
+
+const (
+	Chain Ref = 0
+	Auto  Ref = -1
+)
+
+// ParseRef parses chain, auto or a reference number starting at one.
+func ParseRef(str string) (Ref, error) {
+	switch str {
+	case "", "chain":
+		return Chain, nil
+	case "auto":
+		return Auto, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 1 {
+		return Chain, fmt.Errorf("invalid clap reference %q", str)
+	}
+	return Ref(n), nil
+}
+
+func (r Ref) String() string {
+	switch r {
+	case Chain:
+		return "chain"
+	case Auto:
+		return "auto"
+	}
+	return strconv.Itoa(int(r))
+}
+func (r Ref) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+func (r *Ref) UnmarshalText(b []byte) (err error) {
+	*r, err = ParseRef(string(b))
+	return err
+}
+
+// pair is the result of matching the webs of two waveforms a and b.
+type pair struct {
+	a, b     int // clap offsets in a and b
+	score    int
+	dist     int
+	amb      bool
+	acs, bcs []int
+}
+
+func matchPair(a, b Web) pair {
+	m := match(a, b)
+	p := pair{m.ac, m.bc, m.score, m.dist, m.amb, m.cands(false), m.cands(true)}
+	if m.rev {
+		p = p.swap()
+	}
+	return p
+}
+
+func (p pair) swap() pair {
+	return pair{p.b, p.a, p.score, p.dist, p.amb, p.bcs, p.acs}
+}
+
+// matchRef matches every waveform independently to the reference and returns the results in
+// input order and the summed score. The reference is selected automatically by the highest
+// summed score of all its pairs, if configured. All pairs are matched to report the lag matrix
+// and to flag pairs that disagree with the lags via the reference by more than Tol.
+func (d *Detector) matchRef(at At, ws []pcm.Wave, webs []Web, proms []map[int]float64) ([]Clap, int, error) {
+	n := len(ws)
+	ps := make([][]pair, n)
+	for i := range ps {
+		ps[i] = make([]pair, n)
+	}
+	for i := range webs {
+		for j := i + 1; j < n; j++ {
+			p := matchPair(webs[i], webs[j])
+			if p.a < 0 || p.b < 0 {
+				return nil, 0, fmt.Errorf("sync empty %q or %q", ws[i].Stat().Path, ws[j].Stat().Path)
+			}
+			ps[i][j], ps[j][i] = p, p.swap()
+		}
+	}
+	ref := int(d.Ref) - 1
+	if d.Ref == Auto {
+		best := -1
+		for i := range ps {
+			var sum int
+			for j, p := range ps[i] {
+				if j != i {
+					sum += p.score
+				}
+			}
+			if sum > best {
+				best, ref = sum, i
+			}
+		}
+	}
+	if ref < 0 || ref >= n {
+		return nil, 0, fmt.Errorf("invalid clap reference %s for %d files", d.Ref, n)
+	}
+	// the reference clap is taken from its best pair
+	rp := -1
+	for j, p := range ps[ref] {
+		if j != ref && (rp < 0 || p.score > ps[ref][rp].score) {
+			rp = j
+		}
+	}
+	rc := ps[ref][rp].a
+	var max, score int
+	var amb *AmbiguousError
+	res := make([]Clap, n)
+	for i := range res {
+		// the clap is the matched peak moved by the difference of the reference claps
+		// the row is linked to the matched peak oc in waveform o
+		p, o, oc := ps[ref][i], ref, ps[ref][i].a
+		if i == ref {
+			bp := ps[ref][rp]
+			p, o, oc = pair{a: rc, b: rc, dist: bp.dist, bcs: bp.acs}, rp, bp.b
+		}
+		off := rc + p.b - p.a
+		res[i] = d.clap(at, off, p.dist, proms[i], p.bcs)
+		d.rows(&res[i], webs[i].Vals, p.b, webs[o].Vals, oc)
+		res[i].Conf.Prom = proms[i][p.b]
+		if res[i].Ref = i == ref; !res[i].Ref {
+			score += p.score
+			if p.amb && amb == nil {
+				amb = &AmbiguousError{Path: ws[i].Stat().Path, Score: p.score, Cands: res[i].Cands}
+			}
+		}
+		if off > max {
+			max = off
+		}
+	}
+	tol := int(d.Format.Beats(d.Tol))
+	for i := range res {
+		c := &res[i]
+		c.Off = d.Format.Dur(max) - c.Clap
+		c.Lags = make([]av.Dur, n)
+		for j, p := range ps[i] {
+			if j == i {
+				continue
+			}
+			lag := p.b - p.a
+			c.Lags[j] = d.Format.Dur(lag)
+			via := (ps[ref][j].b - ps[ref][j].a) - (ps[ref][i].b - ps[ref][i].a)
+			if i != ref && j != ref && absInt(lag-via) > tol {
+				c.Bad = append(c.Bad, j)
+			}
+		}
+	}
+	if amb != nil {
+		return res, score, amb
+	}
+	return res, score, nil
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,137 @@
+package clap
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/ffm"
+	"github.com/mb0/qnpdub/av/gen"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// source is the media path and audio stream spec of a loaded waveform.
+type source struct {
+	path, spec string
+}
+
+// refine moves the coarse clap positions in res to the onsets found at the source sample rate
+// and adjusts the sync offsets. It does nothing if refinement is disabled.
+func (d *Detector) refine(res []Clap, ws []pcm.Wave) error {
+	if d.Refine <= 0 {
+		return nil
+	}
+	claps := make([]av.Dur, len(res))
+	for i, w := range ws {
+		c, err := d.RefineClap(w, res[i].Clap)
+		if err != nil {
+			return err
+		}
+		claps[i] = c
+	}
+	moveClaps(res, claps)
+	return nil
+}
+
+// moveClaps sets the claps in res and recomputes the sync offsets relative to the latest clap.
+func moveClaps(res []Clap, claps []av.Dur) {
+	var max av.Dur
+	for i, c := range claps {
+		res[i].Clap = c
+		if c > max {
+			max = c
+		}
+	}
+	for i := range res {
+		res[i].Off = max - res[i].Clap
+	}
+}
+
+// RefineClap decodes a window around the coarse clap of waveform w at the source sample rate and
+// returns the position of the onset in that window. A configured beep is found by its matched
+// filter instead.
+func (d *Detector) RefineClap(w pcm.Wave, clap av.Dur) (av.Dur, error) {
+	src, ok := d.srcs[w.Stat().Path]
+	if !ok {
+		return clap, fmt.Errorf("refine %q: unknown media source", w.Stat().Path)
+	}
+	nfo, err := ffm.Probe(src.path)
+	if err != nil {
+		return clap, err
+	}
+	nfo.ASel = d.Sel
+	f := pcm.Format{PCM: pcm.S16LE, Rate: av.Hz(48000)}
+	if a := nfo.Audio(); a != nil && a.Int("sample_rate") > 0 {
+		f.Rate = av.Hz(int(a.Int("sample_rate")))
+	}
+	// the coarse clap is usually late, so we look further back
+	start, end := clap-d.Refine, clap+d.Refine/2
+	var tmpl []float64
+	if d.Beep != "" {
+		// a matched beep is off by at most a sample, but the window must cover the whole beep
+		bp, err := gen.ParseBeep(d.Beep)
+		if err != nil {
+			return clap, err
+		}
+		tmpl = bp.Template(f.Rate)
+		start, end = clap-d.Refine/2, clap+d.Refine/2+f.Dur(len(tmpl))
+	}
+	if start < 0 {
+		start = 0
+	}
+	var b bytes.Buffer
+	err = ffm.GenPCMWindowInto(src.path, src.spec, start, end-start, &b, f)
+	if err != nil {
+		return clap, fmt.Errorf("refine %q: %w", src.path, err)
+	}
+	smpls := f.Add16(b.Bytes(), nil)
+	if len(smpls) == 0 {
+		return clap, fmt.Errorf("refine %q: empty window", src.path)
+	}
+	if tmpl != nil {
+		off, _ := Matched(smpls, tmpl)
+		return start + f.Dur(off), nil
+	}
+	return start + f.Dur(Onset(smpls, f.Beats(av.S/1000))), nil
+}
+
+// Onset returns the index of the clap onset in smpls with a hold of samples.
+// It finds the loudest sample and walks back to the first sample above the onset threshold that
+// is not preceded by another such sample within hold. The threshold is the larger of a twentieth
+// of the peak and six standard deviations above the mean level of the first quarter of smpls.
+func Onset(smpls []int16, hold int) int {
+	var peak, p int
+	for i, s := range smpls {
+		if a := abs(s); a > peak {
+			peak, p = a, i
+		}
+	}
+	var mean, sqs float64
+	n := len(smpls) / 4
+	for _, s := range smpls[:n] {
+		a := float64(abs(s))
+		mean += a
+		sqs += a * a
+	}
+	var trsh float64
+	if n > 0 {
+		mean /= float64(n)
+		trsh = mean + 6*math.Sqrt(math.Max(sqs/float64(n)-mean*mean, 0))
+	}
+	trsh = math.Max(trsh, float64(peak)/20)
+	on := p
+	for i := p; i >= 0 && on-i <= hold; i-- {
+		if float64(abs(smpls[i])) >= trsh {
+			on = i
+		}
+	}
+	return on
+}
+
+func abs(s int16) int {
+	if s < 0 {
+		return -int(s)
+	}
+	return int(s)
+}
@@ -0,0 +1,116 @@
+package clap
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+	"github.com/mb0/qnpdub/peak"
+)
+
+// Scan holds the settings to scan a whole recording for takes separated by clap markers.
+type Scan struct {
+	Silence float64 `json:"silence"` // silence level as divisor of the loudest chunk level
+	Gap     av.Dur  `json:"gap"`     // min silence before a clap marker
+	Pad     av.Dur  `json:"pad"`     // padding before the clap and after the last sound of a take
+}
+
+// DefScan is the default scan with markers after two seconds of silence and half a second padding.
+var DefScan = Scan{Silence: 20, Gap: 2 * av.S, Pad: av.S / 2}
+
+// AddFlags adds the scan flags to fs.
+func (s *Scan) AddFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&s.Silence, "silence", s.Silence, "take silence ratio")
+	fs.TextVar(&s.Gap, "gap", s.Gap, "take min silence before clap")
+	fs.TextVar(&s.Pad, "pad", s.Pad, "take padding")
+}
+
+// Take is a proposed take of a recording with the clap marker at its start.
+type Take struct {
+	Start av.Dur  `json:"start"`
+	End   av.Dur  `json:"end"`
+	Clap  av.Dur  `json:"clap"`
+	Prom  float64 `json:"prom"`
+}
+
+// Dur returns the duration of the take.
+func (t Take) Dur() av.Dur { return t.End - t.Start }
+
+// level holds the summary of one chunk of a scanned waveform.
+type level struct {
+	off, end int // sample offsets
+	amp      int // max absolute sample value
+	mao      int // offset of the max value
+	sigs     bool
+	prom     float64
+}
+
+// Scan scans w forward for all clap markers that follow silence and returns the proposed takes.
+// Each take starts padded before its clap and ends padded after its last sound, but never
+// overlaps the next take.
+func (d *Detector) Scan(w pcm.Wave, s Scan) ([]Take, error) {
+	wi, err := d.checkWave(w)
+	if err != nil {
+		return nil, err
+	}
+	if s.Silence < 1 || s.Gap < 0 || s.Pad < 0 {
+		return nil, fmt.Errorf("invalid take scan %+v", s)
+	}
+	if err = d.setup(); err != nil {
+		return nil, err
+	}
+	d.Reset(false)
+	d.next = -1
+	lvls := make([]level, 0, av.Chunks(d.Chunk, wi.Count*wi.Bytes))
+	r := av.NewChunkReader(w, d.bbuf)
+	var loud int
+	var pk peak.Peaks[int16]
+	err = r.ReadChunks(0, wi.Count*wi.Bytes, func(off int, buf []byte) error {
+		d.sbuf = d.Format.PCM.Add(buf, d.sbuf[:0])
+		if err := d.filter(w, off); err != nil {
+			return err
+		}
+		soff := off / d.Format.Bytes
+		d.FeedTo(&pk, soff, d.sbuf)
+		l := level{off: soff, end: soff + len(d.sbuf), mao: pk.Mao, sigs: len(pk.Sigs) > 0}
+		l.amp = av.Max(int(pk.Max), -int(pk.Min))
+		if l.sigs {
+			l.prom = prom(pk)
+		}
+		loud = av.Max(loud, l.amp)
+		lvls = append(lvls, l)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	quiet := int(float64(loud) / s.Silence)
+	cut := int(float64(loud) / d.Loud)
+	gap := int(wi.Beats(s.Gap))
+	pad := int(wi.Beats(s.Pad))
+	var res []Take
+	// sound is the end of the last chunk with sound before the current silence,
+	// the start of the file counts as sound until the gap of silence has passed
+	var sound int
+	for _, l := range lvls {
+		if l.amp < quiet {
+			continue
+		}
+		// a marker is a loud peak after silence for at least the gap duration
+		if !l.sigs || l.amp < cut || l.mao-sound < gap {
+			sound = l.end
+			continue
+		}
+		start := av.Max(l.mao-pad, 0)
+		if n := len(res) - 1; n >= 0 {
+			res[n].End = wi.Dur(av.Min(sound+pad, start))
+		}
+		res = append(res, Take{Start: wi.Dur(start), Clap: wi.Dur(l.mao), Prom: l.prom})
+		sound = l.end
+	}
+	if n := len(res) - 1; n >= 0 {
+		res[n].End = wi.Dur(av.Min(sound+pad, wi.Count))
+	}
+	return res, nil
+}
@@ -0,0 +1,215 @@
+// Package dsp implements simple audio filters applied to pcm samples.
+//
+// The biquad filters follow the audio eq cookbook by Robert Bristow-Johnson.
+package dsp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// Filter processes a stream of samples one by one.
+type Filter interface {
+	// Process returns the filtered value for the next sample x.
+	Process(x float64) float64
+	// Reset clears the filter state.
+	Reset()
+}
+
+// Biquad is a second order iir filter.
+type Biquad struct {
+	B0, B1, B2, A1, A2 float64 // normalized coefficients
+	x1, x2, y1, y2     float64
+}
+
+// HighPass returns a biquad high-pass filter with cutoff freq in hz and quality q at rate.
+func HighPass(rate av.Rate, freq, q float64) *Biquad {
+	w, alpha := coefs(rate, freq, q)
+	c := math.Cos(w)
+	return norm(1+alpha, (1+c)/2, -(1 + c), (1+c)/2, -2*c, 1-alpha)
+}
+
+// LowPass returns a biquad low-pass filter with cutoff freq in hz and quality q at rate.
+func LowPass(rate av.Rate, freq, q float64) *Biquad {
+	w, alpha := coefs(rate, freq, q)
+	c := math.Cos(w)
+	return norm(1+alpha, (1-c)/2, 1-c, (1-c)/2, -2*c, 1-alpha)
+}
+
+// BandPass returns a biquad band-pass filter with center freq in hz and quality q at rate.
+// The peak gain is 0 db.
+func BandPass(rate av.Rate, freq, q float64) *Biquad {
+	w, alpha := coefs(rate, freq, q)
+	return norm(1+alpha, alpha, 0, -alpha, -2*math.Cos(w), 1-alpha)
+}
+
+func coefs(rate av.Rate, freq, q float64) (w, alpha float64) {
+	fs := float64(rate.Num) / float64(rate.Den)
+	w = 2 * math.Pi * freq / fs
+	return w, math.Sin(w) / (2 * q)
+}
+
+func norm(a0, b0, b1, b2, a1, a2 float64) *Biquad {
+	return &Biquad{B0: b0 / a0, B1: b1 / a0, B2: b2 / a0, A1: a1 / a0, A2: a2 / a0}
+}
+
+func (f *Biquad) Process(x float64) float64 {
+	y := f.B0*x + f.B1*f.x1 + f.B2*f.x2 - f.A1*f.y1 - f.A2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+func (f *Biquad) Reset() { f.x1, f.x2, f.y1, f.y2 = 0, 0, 0, 0 }
+
+// DCBlock is a first order filter that removes the dc offset.
+type DCBlock struct {
+	R      float64 // pole radius close to one
+	x1, y1 float64
+}
+
+// NewDCBlock returns a dc blocker with a 10hz corner at rate.
+func NewDCBlock(rate av.Rate) *DCBlock {
+	fs := float64(rate.Num) / float64(rate.Den)
+	return &DCBlock{R: 1 - 2*math.Pi*10/fs}
+}
+
+func (f *DCBlock) Process(x float64) float64 {
+	y := x - f.x1 + f.R*f.y1
+	f.x1, f.y1 = x, y
+	return y
+}
+func (f *DCBlock) Reset() { f.x1, f.y1 = 0, 0 }
+
+// Envelope is an envelope follower with separate attack and release times.
+type Envelope struct {
+	Attack, Release float64 // smoothing coefficients
+	v               float64
+}
+
+// NewEnvelope returns an envelope follower with attack and release durations at rate.
+func NewEnvelope(rate av.Rate, attack, release av.Dur) *Envelope {
+	return &Envelope{Attack: smooth(rate, attack), Release: smooth(rate, release)}
+}
+
+func smooth(rate av.Rate, d av.Dur) float64 {
+	n := float64(rate.Num) / float64(rate.Den) * d.Val().Seconds()
+	if n <= 0 {
+		return 0
+	}
+	return math.Exp(-1 / n)
+}
+
+func (f *Envelope) Process(x float64) float64 {
+	x = math.Abs(x)
+	c := f.Release
+	if x > f.v {
+		c = f.Attack
+	}
+	f.v = c*f.v + (1-c)*x
+	return f.v
+}
+func (f *Envelope) Reset() { f.v = 0 }
+
+// Chain is a list of filters applied in order.
+type Chain []Filter
+
+func (c Chain) Process(x float64) float64 {
+	for _, f := range c {
+		x = f.Process(x)
+	}
+	return x
+}
+func (c Chain) Reset() {
+	for _, f := range c {
+		f.Reset()
+	}
+}
+
+// Apply filters the samples in place and clamps the results to the int16 range.
+func (c Chain) Apply(smpls []int16) {
+	if len(c) == 0 {
+		return
+	}
+	for i, s := range smpls {
+		v := math.Round(c.Process(float64(s)))
+		smpls[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, v)))
+	}
+}
+
+// Chains maps names to filter chain specs.
+var Chains = map[string]string{
+	// claps are broadband, so we remove the dc offset and low thumps of drums and congas
+	"clap": "dc,hp:1000",
+}
+
+// ParseChain parses a comma separated filter chain spec at rate. The filters are: dc for a dc
+// blocker, hp, lp or bp with a frequency and optional quality like hp:1000 or bp:2000:2, and env
+// with attack and release durations like env:0.001:0.05. Named chains from Chains can be used.
+func ParseChain(spec string, rate av.Rate) (Chain, error) {
+	if s, ok := Chains[spec]; ok {
+		spec = s
+	}
+	var res Chain
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		f, err := parseFilter(s, rate)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+func parseFilter(s string, rate av.Rate) (Filter, error) {
+	args := strings.Split(s, ":")
+	name, args := args[0], args[1:]
+	switch name {
+	case "dc":
+		if len(args) == 0 {
+			return NewDCBlock(rate), nil
+		}
+	case "hp", "lp", "bp":
+		if len(args) < 1 || len(args) > 2 {
+			break
+		}
+		freq, err := strconv.ParseFloat(args[0], 64)
+		nyq := float64(rate.Num) / float64(rate.Den) / 2
+		if err != nil || freq <= 0 || freq >= nyq {
+			break
+		}
+		q := math.Sqrt2 / 2
+		if len(args) > 1 {
+			if q, err = strconv.ParseFloat(args[1], 64); err != nil || q <= 0 {
+				break
+			}
+		}
+		switch name {
+		case "hp":
+			return HighPass(rate, freq, q), nil
+		case "lp":
+			return LowPass(rate, freq, q), nil
+		}
+		return BandPass(rate, freq, q), nil
+	case "env":
+		if len(args) != 2 {
+			break
+		}
+		a, err := av.ParseDur(args[0])
+		if err != nil {
+			break
+		}
+		r, err := av.ParseDur(args[1])
+		if err != nil {
+			break
+		}
+		return NewEnvelope(rate, a, r), nil
+	}
+	return nil, fmt.Errorf("invalid filter %q", s)
+}
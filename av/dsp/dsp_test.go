@@ -0,0 +1,85 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+func TestFilters(t *testing.T) {
+	rate := av.Hz(8000)
+	tests := []struct {
+		spec     string
+		freq     float64
+		min, max float64 // expected gain range
+	}{
+		{"hp:1000", 100, 0, .05},
+		{"hp:1000", 3000, .9, 1.1},
+		{"lp:1000", 100, .9, 1.1},
+		{"lp:1000", 3000, 0, .1},
+		{"bp:1000:2", 1000, .9, 1.1},
+		{"bp:1000:2", 200, 0, .2},
+		{"clap", 60, 0, .01},
+		{"clap", 2500, .9, 1.1},
+		{"dc", 500, .95, 1.05},
+	}
+	for _, test := range tests {
+		c, err := ParseChain(test.spec, rate)
+		if err != nil {
+			t.Errorf("parse %s: %v", test.spec, err)
+			continue
+		}
+		if g := gain(c, rate, test.freq); g < test.min || g > test.max {
+			t.Errorf("%s at %ghz got gain %.3f want %g-%g", test.spec, test.freq, g, test.min, test.max)
+		}
+	}
+	for _, spec := range []string{"hp", "lp:5000", "xx:1", "env:1"} {
+		if _, err := ParseChain(spec, rate); err == nil {
+			t.Errorf("parse %s want error", spec)
+		}
+	}
+}
+
+func TestDCAndEnvelope(t *testing.T) {
+	rate := av.Hz(8000)
+	smpls := make([]int16, 8000)
+	for i := range smpls {
+		smpls[i] = 1000
+	}
+	c, _ := ParseChain("dc", rate)
+	c.Apply(smpls)
+	if v := smpls[len(smpls)-1]; v > 10 {
+		t.Errorf("dc block got %d want 0", v)
+	}
+	env := NewEnvelope(rate, av.S/1000, av.S/10)
+	var v float64
+	for i := 0; i < 80; i++ {
+		v = env.Process(1000)
+	}
+	if v < 990 {
+		t.Errorf("envelope attack got %g", v)
+	}
+	for i := 0; i < 800; i++ {
+		v = env.Process(0)
+	}
+	if v > 1000/math.E+10 || v < 1000/math.E-10 {
+		t.Errorf("envelope release got %g want %g", v, 1000/math.E)
+	}
+}
+
+// gain returns the amplitude gain of c for a sine at freq after the filters settled.
+func gain(c Chain, rate av.Rate, freq float64) float64 {
+	c.Reset()
+	fs := float64(rate.Num)
+	var in, out float64
+	for i := 0; i < 16000; i++ {
+		x := math.Sin(2 * math.Pi * freq * float64(i) / fs)
+		y := c.Process(x)
+		if i >= 8000 {
+			in = math.Max(in, math.Abs(x))
+			out = math.Max(out, math.Abs(y))
+		}
+	}
+	return out / in
+}
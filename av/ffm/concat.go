@@ -3,30 +3,54 @@ package ffm
 import (
 	"fmt"
 	"strings"
+
+	"github.com/mb0/qnpdub/av"
 )
 
 // Concat concatenates video and audio streams and creates the combined result at output.
 // If you want to combine both for a list of video files, pass the same list audio files as well.
+// All inputs are converted to the output format, see Opts.Out and Harmonize.
+// Clips are joined with hard cuts or the transitions configured with Opts.Trans and Opts.Joins.
+// Transitions overlap both clips and shorten the output, see Opts.Length.
+// Title cards and overlays are added as configured with Opts.Text.
+// Tags and chapters for each segment and the clap point are muxed into output, see ConcatMeta.
 //
 // The following is approx the result of an example with multiple video and one audio file:
 //
 //	ffmpeg -v fail -filter_complex ' \
 //	     movie=<video1.mp4>:seek_point=<voff>, fps=<fps>, scale=<scale> [v1]; \
-//	     movie=<videoN.mp4>, fps=<fps>, scale=<scale> [vN]; \
+//	     movie=<videoN.mp4>, fps=<fps>, scale=<scale>, format=<pix>, setsar=<sar> [vN]; \
 //	     [v1] [v2] [vN] concat=n=N:v=1:a=0 [outv];' \
 //	     -filter_complex ' \
 //	     amovie=<audio.flac>:seek_point=<aoff> [outa];' \
 //	     -map [outv] -map [outa] \
 //	     -c:v h264 -g 18 -bf 2 -c:a aac \
 //	     -t <dur> <output.mp4>
-func (o *Opts) Concat(output string, videos, audios []string) error {
+func (o *Opts) Concat(output string, videos, audios []*Info) error {
+	f := o.Out.Harmonize(videos, audios)
+	if o.Text.On() {
+		done, err := o.Text.writeFiles()
+		if err != nil {
+			return fmt.Errorf("concat titles: %w", err)
+		}
+		defer done()
+	}
 	var args []string
-	args = append(args, o.videoArgs(videos...)...)
-	args = append(args, o.audioArgs(audios...)...)
+	if m := o.ConcatMeta(videos, audios); !m.Zero() {
+		margs, done, err := o.writeMeta(m)
+		if err != nil {
+			return fmt.Errorf("concat meta: %w", err)
+		}
+		defer done()
+		args = append(args, margs...)
+	}
+	args = append(args, o.videoArgs(f, videos...)...)
+	args = append(args, o.audioArgs(f, audios...)...)
 	if o.Yes {
 		args = append(args, "-y")
 	}
-	if o.Dur != 0 {
+	// title cards trim the duration in the filter graph instead
+	if o.Dur != 0 && !o.Text.Cards() {
 		args = append(args, "-t", o.Dur.String())
 	}
 	cmd := o.Cmd("ffmpeg", DefLog, args, Args(output))
@@ -37,21 +61,126 @@ func (o *Opts) Concat(output string, videos, audios []string) error {
 	return nil
 }
 
-func (o *Opts) videoArgs(paths ...string) []string {
-	if len(paths) == 0 {
+// textDur returns the duration to trim in the filter graph before title cards are added.
+func (o *Opts) textDur() av.Dur {
+	if o.Text.Cards() {
+		return o.Dur
+	}
+	return 0
+}
+
+// Harmonize returns a copy of f with zero fields set to the format of the first video or audio.
+func (f Format) Harmonize(videos, audios []*Info) Format {
+	for _, nfo := range videos {
+		if v := nfo.Video(); v != nil {
+			if f.Pix == "" {
+				f.Pix = v.Str("pix_fmt")
+			}
+			if f.SAR.Zero() {
+				f.SAR = sar(v)
+			}
+			break
+		}
+	}
+	for _, nfo := range audios {
+		if a := nfo.Audio(); a != nil {
+			if f.Rate == 0 {
+				f.Rate = int(a.Int("sample_rate"))
+			}
+			if f.Sample == "" {
+				f.Sample = a.Str("sample_fmt")
+			}
+			if f.Layout == "" {
+				f.Layout = layout(a)
+			}
+			break
+		}
+	}
+	return f
+}
+
+// videoFilter writes the filters required to convert the video stream v to format f.
+func (f Format) videoFilter(fs *strings.Builder, v Obj, scaled bool) {
+	if f.Pix != "" && v.Str("pix_fmt") != f.Pix {
+		fmt.Fprintf(fs, ", format=%s", f.Pix)
+	}
+	// scaling may change the sample aspect ratio
+	if !f.SAR.Zero() && (scaled || sar(v) != f.SAR) {
+		fmt.Fprintf(fs, ", setsar=%d/%d", f.SAR.W, f.SAR.H)
+	}
+}
+
+// audioFilter writes the filters required to convert the audio stream a to format f.
+func (f Format) audioFilter(fs *strings.Builder, a Obj) {
+	rate := f.Rate != 0 && int(a.Int("sample_rate")) != f.Rate
+	if rate {
+		fmt.Fprintf(fs, ", aresample=%d", f.Rate)
+	}
+	smpl := f.Sample != "" && a.Str("sample_fmt") != f.Sample
+	lay := f.Layout != "" && layout(a) != f.Layout
+	if !rate && !smpl && !lay {
+		return
+	}
+	var opts []string
+	if f.Sample != "" {
+		opts = append(opts, "sample_fmts="+f.Sample)
+	}
+	if f.Rate != 0 {
+		opts = append(opts, fmt.Sprintf("sample_rates=%d", f.Rate))
+	}
+	if f.Layout != "" {
+		opts = append(opts, "channel_layouts="+f.Layout)
+	}
+	fmt.Fprintf(fs, ", aformat=%s", strings.Join(opts, ":"))
+}
+
+// sar returns the sample aspect ratio of video stream v and defaults to 1:1.
+func sar(v Obj) av.Ratio {
+	r := v.Ratio("sample_aspect_ratio")
+	if r.W == 0 || r.H == 0 {
+		r = av.Ratio{W: 1, H: 1}
+	}
+	return r
+}
+
+// layout returns the channel layout of audio stream a or the channel count as layout.
+func layout(a Obj) string {
+	if l := a.Str("channel_layout"); l != "" && l != "unknown" {
+		return l
+	}
+	if n := a.Int("channels"); n > 0 {
+		return fmt.Sprintf("%dc", n)
+	}
+	return ""
+}
+
+func (o *Opts) videoArgs(f Format, nfos ...*Info) []string {
+	if len(nfos) == 0 {
 		return nil
 	}
 	res := Args("-filter_complex", "", "-map", "[outv]")
 	res = append(res, o.VCodec...)
+	fps := o.Fps
+	if fps.Den == 0 && (o.hasTrans(len(nfos)-1) || o.Text.Cards()) {
+		// xfade and concat with cards require the same frame rate and time base
+		fps = nfos[0].Video().Rate("r_frame_rate")
+	}
+	out, end := "[outv]", "\n"
+	if o.Text.On() {
+		out, end = "[vcat]", ";\n"
+	}
 	var fs strings.Builder
-	for i, path := range paths {
-		fmt.Fprintf(&fs, "movie=%s", path)
+	for i, nfo := range nfos {
+		fmt.Fprintf(&fs, "movie=%s", nfo.Path)
+		if spec := nfo.Spec("video"); spec != "" {
+			fmt.Fprintf(&fs, ":s=%s", spec)
+		}
 		if i == 0 && o.Vod > 0 {
 			fmt.Fprintf(&fs, ", trim=start=%s", o.Vod.Secs())
 		}
 		fmt.Fprintf(&fs, ", setpts=(PTS-STARTPTS)")
-		if o.Fps.Den != 0 {
-			fmt.Fprintf(&fs, ", fps=%s", o.Fps)
+		if fps.Den != 0 {
+			fmt.Fprintf(&fs, ", fps=%s", fps)
 		}
 		if o.Rot != 0 {
 			fmt.Fprintf(&fs, ", rotate=PI/%d", 180/o.Rot)
@@ -62,46 +191,63 @@ func (o *Opts) videoArgs(paths ...string) []string {
 		if !o.Dim.Zero() {
 			fmt.Fprintf(&fs, ", scale=%s", o.Dim)
 		}
-		if len(paths) > 1 {
+		if v := nfo.Video(); v != nil {
+			f.videoFilter(&fs, v, !o.Dim.Zero())
+		}
+		if len(nfos) > 1 {
 			fmt.Fprintf(&fs, " [v%d];\n", i+1)
 		} else {
-			fmt.Fprintf(&fs, " [outv]\n")
+			fmt.Fprintf(&fs, " %s%s", out, end)
 		}
 	}
-	if len(paths) > 1 {
-		for i := range paths {
-			fmt.Fprintf(&fs, "[v%d] ", i+1)
-		}
-		fmt.Fprintf(&fs, "concat=n=%d:v=1:a=0 [outv]\n", len(paths))
+	if len(nfos) > 1 {
+		o.join(&fs, 'v', nfos, o.Vod, out, end)
+	}
+	if o.Text.On() {
+		w, h := o.outSize(nfos[0].Video())
+		o.Text.video(&fs, out, "[outv]", o.textDur(), w, h, fps, f)
 	}
 	res[1] = fs.String()
 	return res
 }
 
-func (o *Opts) audioArgs(paths ...string) []string {
-	if len(paths) == 0 {
+func (o *Opts) audioArgs(f Format, nfos ...*Info) []string {
+	if len(nfos) == 0 {
 		return nil
 	}
 	res := Args("-filter_complex", "", "-map", "[outa]")
 	res = append(res, o.ACodec...)
+	out, end := "[outa]", "\n"
+	if o.Text.On() {
+		out, end = "[acat]", ";\n"
+	}
 	var fs strings.Builder
-	for i, path := range paths {
-		fmt.Fprintf(&fs, "amovie=%s", path)
+	for i, nfo := range nfos {
+		fmt.Fprintf(&fs, "amovie=%s", nfo.Path)
+		if spec := nfo.Spec("audio"); spec != "" {
+			fmt.Fprintf(&fs, ":s=%s", spec)
+		}
 		if i == 0 && o.Aod > 0 {
 			fmt.Fprintf(&fs, ", atrim=start=%s", o.Aod.Secs())
 		}
 		fmt.Fprintf(&fs, ", asetpts=(PTS-STARTPTS)")
-		if len(paths) > 1 {
+		if o.Tempo > 0 && o.Tempo != 1 {
+			fmt.Fprintf(&fs, ", atempo=%.6f", o.Tempo)
+		}
+		if a := nfo.Audio(); a != nil {
+			f.audioFilter(&fs, a)
+		}
+		if len(nfos) > 1 {
 			fmt.Fprintf(&fs, " [a%d];\n", i+1)
 		} else {
-			fmt.Fprintf(&fs, " [outa]\n")
+			fmt.Fprintf(&fs, " %s%s", out, end)
 		}
 	}
-	if len(paths) > 1 {
-		for i := range paths {
-			fmt.Fprintf(&fs, "[a%d] ", i+1)
-		}
-		fmt.Fprintf(&fs, "concat=n=%d:v=0:a=1 [outa]\n", len(paths))
+	if len(nfos) > 1 {
+		o.join(&fs, 'a', nfos, o.Aod, out, end)
+	}
+	if o.Text.On() {
+		o.Text.audio(&fs, out, "[outa]", o.textDur(), f)
 	}
 	res[1] = fs.String()
 	return res
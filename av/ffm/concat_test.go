@@ -0,0 +1,158 @@
+package ffm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+func TestHarmonize(t *testing.T) {
+	phone := &Info{Path: "phone.mp4", Streams: Objs{
+		{"codec_type": "video", "pix_fmt": "yuvj420p", "sample_aspect_ratio": "1:1"},
+		{"codec_type": "audio", "sample_rate": "44100", "sample_fmt": "fltp", "channel_layout": "mono"},
+	}}
+	rec := &Info{Path: "rec.flac", Streams: Objs{
+		{"codec_type": "audio", "sample_rate": "48000", "sample_fmt": "s32", "channels": 2.0},
+	}}
+	f := Format{Rate: 48000}.Harmonize([]*Info{phone}, []*Info{phone, rec})
+	want := Format{Rate: 48000, Sample: "fltp", Layout: "mono", Pix: "yuvj420p", SAR: f.SAR}
+	if f != want || f.SAR.String() != "1:1" {
+		t.Errorf("harmonize got %+v want %+v", f, want)
+	}
+	res := Def().audioArgs(f, phone, rec)
+	got := res[1]
+	for _, want := range []string{
+		"amovie=phone.mp4, asetpts=(PTS-STARTPTS), aresample=48000, " +
+			"aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=mono [a1]",
+		"amovie=rec.flac, asetpts=(PTS-STARTPTS), " +
+			"aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=mono [a2]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("audio filter got %s\nwant %s", got, want)
+		}
+	}
+	res = Def().videoArgs(Format{Pix: "yuv420p"}, phone)
+	if want := "movie=phone.mp4, setpts=(PTS-STARTPTS), format=yuv420p [outv]\n"; res[1] != want {
+		t.Errorf("video filter got %q want %q", res[1], want)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	clip := func(path, dur string) *Info {
+		return &Info{Path: path, Format: Obj{"duration": dur}, Streams: Objs{
+			{"codec_type": "video", "r_frame_rate": "30/1"},
+		}}
+	}
+	nfos := []*Info{clip("a.mp4", "10"), clip("b.mp4", "20"), clip("c.mp4", "5")}
+	o := Def()
+	o.Flags().Parse([]string{"-trans", "fade:1", "-join", "2=cut"})
+	if got := o.Length(nfos, 0); got.String() != "34" {
+		t.Errorf("length got %s want 34", got)
+	}
+	var b strings.Builder
+	o.join(&b, 'v', nfos, 0, "[outv]", "\n")
+	want := "[v1] [v2] xfade=transition=fade:duration=1:offset=9 [vx1];\n" +
+		"[vx1] [v3] concat=n=2:v=1:a=0 [outv]\n"
+	if got := b.String(); got != want {
+		t.Errorf("join got %q want %q", got, want)
+	}
+	o.Joins[2] = Trans{Dur: av.S / 2}
+	b.Reset()
+	o.join(&b, 'a', nfos, av.S, "[outa]", "\n")
+	want = "[a1] [a2] acrossfade=d=1 [ax1];\n" +
+		"[ax1] [a3] acrossfade=d=0.500 [outa]\n"
+	if got := b.String(); got != want {
+		t.Errorf("join got %q want %q", got, want)
+	}
+	if got := o.Overlap(2).String(); got != "1.500" {
+		t.Errorf("overlap got %s want 1.500", got)
+	}
+	// the xfade offset uses the video stream duration if known
+	nfos[0].Streams[0]["duration"] = "9.500000"
+	b.Reset()
+	o.join(&b, 'v', nfos[:2], 0, "[outv]", "\n")
+	want = "[v1] [v2] xfade=transition=fade:duration=1:offset=8.500 [outv]\n"
+	if got := b.String(); got != want {
+		t.Errorf("join got %q want %q", got, want)
+	}
+	res := o.videoArgs(Format{}, nfos...)
+	if !strings.Contains(res[1], "fps=30/1") {
+		t.Errorf("video args missing fps for xfade %s", res[1])
+	}
+}
+
+func TestTitles(t *testing.T) {
+	nfo := &Info{Path: "a.mp4", Format: Obj{"duration": "10"}, Streams: Objs{
+		{"codec_type": "video", "r_frame_rate": "30/1", "width": 1920.0, "height": 1080.0},
+		{"codec_type": "audio", "sample_rate": "48000", "channel_layout": "stereo"},
+	}}
+	o := Def()
+	o.Flags().Parse([]string{"-dim", "720:-2", "-dur", "8", "-title", "Song",
+		"-artist", "Band", "-dubber", "Me", "-intro", "2", "-lower", "4", "-fade", "0.5"})
+	if got := o.Text.Credits.Intro(); got != "Song by Band\ndubbed by Me" {
+		t.Errorf("intro text got %q", got)
+	}
+	o.Text.dir = "/tmp"
+	f := o.Out.Harmonize([]*Info{nfo}, []*Info{nfo})
+	v := o.videoArgs(f, nfo)[1]
+	for _, want := range []string{
+		"scale=720:-2, setsar=1/1 [vcat];\n[vcat] trim=duration=8, drawtext=textfile=/tmp/lower.txt",
+		":enable='between(t,0,4)':alpha='if(lt(t,0.500),t/0.500,if(gt(t,3.500),(4-t)/0.500,1))' [vmain];\n",
+		"color=c=black:s=720x406:d=2:r=30/1, setsar=1/1, drawtext=textfile=/tmp/intro.txt",
+		"[vintro] [vmain] concat=n=2:v=1:a=0 [outv]\n",
+	} {
+		if !strings.Contains(v, want) {
+			t.Errorf("video args got %s\nwant %s", v, want)
+		}
+	}
+	a := o.audioArgs(f, nfo)[1]
+	want := "amovie=a.mp4, asetpts=(PTS-STARTPTS) [acat];\n" +
+		"[acat] atrim=duration=8 [amain];\n" +
+		"anullsrc=d=2:r=48000:cl=stereo [aintro];\n" +
+		"[aintro] [amain] concat=n=2:v=0:a=1 [outa]\n"
+	if a != want {
+		t.Errorf("audio args got %q\nwant %q", a, want)
+	}
+}
+
+func TestConcatMeta(t *testing.T) {
+	clip := func(path, dur string) *Info {
+		return &Info{Path: "/rec/" + path, Format: Obj{"duration": dur}}
+	}
+	o := Def()
+	o.Flags().Parse([]string{"-vod", "2", "-trans", "fade:1", "-title", "Song", "-meta", "comment=a;b"})
+	o.Clap = 25 * av.S
+	m := o.ConcatMeta([]*Info{clip("a.mp4", "10"), clip("b.mp4", "20")}, nil)
+	var b strings.Builder
+	m.WriteTo(&b)
+	want := ";FFMETADATA1\ncomment=a\\;b\ntitle=Song\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=7000\ntitle=a.mp4\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=7000\nEND=27000\ntitle=b.mp4\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=25000\nEND=27000\ntitle=clap\n"
+	if got := b.String(); got != want {
+		t.Errorf("meta got %q\nwant %q", got, want)
+	}
+	// sync sets the duration to an end clap
+	o = Def()
+	o.Clap, o.Dur = 25*av.S, 25*av.S
+	m = o.ConcatMeta([]*Info{clip("a.mp4", "30")}, nil)
+	if want := (Chapter{Start: 24 * av.S, End: 25 * av.S, Title: "clap"}); len(m.Chapters) != 1 ||
+		m.Chapters[0] != want {
+		t.Errorf("end clap chapters got %+v want %+v", m.Chapters, want)
+	}
+	o.Flags().Parse([]string{"-title", "Song", "-intro", "2", "-outro", "3"})
+	m = o.ConcatMeta([]*Info{clip("a.mp4", "30")}, nil)
+	if want := (Chapter{Start: 27 * av.S, End: 30 * av.S, Title: "clap"}); len(m.Chapters) != 1 ||
+		m.Chapters[0] != want {
+		t.Errorf("end clap with outro chapters got %+v want %+v", m.Chapters, want)
+	}
+	nfo := &Info{Format: Obj{"tags": map[string]any{"TITLE": "Song"}}, Chapters: Objs{
+		{"start_time": "0.000000", "end_time": "7.000000", "tags": map[string]any{"title": "a.mp4"}},
+	}}
+	got := nfo.Meta()
+	if got.Tags["title"] != "Song" || len(got.Chapters) != 1 ||
+		got.Chapters[0] != (Chapter{Start: 0, End: 7 * av.S, Title: "a.mp4"}) {
+		t.Errorf("probe meta got %+v", got)
+	}
+}
@@ -0,0 +1,32 @@
+package ffm
+
+import (
+	"fmt"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// Cut writes the span of dur at start of the media file at path to output.
+// If copy is true all streams are copied without re-encoding. The cut then starts at the key frame
+// before start, which is usually fine for cuts in silence. Otherwise the streams are encoded with
+// the configured codecs and the cut is frame accurate.
+//
+//	ffmpeg -v error -ss <start> -i <path> -t <dur> -map 0 -c copy <output>
+func (o *Opts) Cut(path, output string, start, dur av.Dur, copy bool) error {
+	args := Args("-ss", start.String(), "-i", path, "-t", dur.String(), "-map", "0")
+	if copy {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	} else {
+		args = append(args, o.VCodec...)
+		args = append(args, o.ACodec...)
+	}
+	if o.Yes {
+		args = append(args, "-y")
+	}
+	cmd := o.Cmd("ffmpeg", DefLog, args, Args(output))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cut err: %v\n%v\n%s", err, cmd.Args, out)
+	}
+	return nil
+}
@@ -14,7 +14,7 @@ var (
 	DefLog    = []string{"-v", "error"}
 	DefVCodec = []string{"-c:v", "h264", "-g", "18", "-bf", "2"}
 	DefACodec = []string{"-c:a", "aac"}
-	DefProbe  = []string{"-print_format", "json", "-show_format", "-show_streams"}
+	DefProbe  = []string{"-print_format", "json", "-show_format", "-show_streams", "-show_chapters"}
 )
 
 // Def returns the common default options for operations.
@@ -23,6 +23,8 @@ func Def() *Opts {
 		Global: DefLog,
 		VCodec: DefVCodec,
 		ACodec: DefACodec,
+		Joins:  make(Joins),
+		Meta:   Meta{Tags: make(Tags)},
 	}
 }
 
@@ -38,6 +40,25 @@ type Opts struct {
 	Dur    av.Dur
 	Rot    int
 	Yes    bool
+	Out    Format
+	Trans  Trans // default transition
+	Joins  Joins // transition overrides
+	Text   Titles
+	Meta   Meta    // tags and chapters added to concat output
+	Clap   av.Dur  // clap point in concat output
+	VSel   Sel     // video stream selector
+	ASel   Sel     // audio stream selector
+	Tempo  float64 // audio speed to compensate clock drift
+}
+
+// Format describes the stream format all concatenated inputs are converted to.
+// Zero fields are filled in from the first input stream of that kind.
+type Format struct {
+	Rate   int      // audio sample rate
+	Sample string   // audio sample format
+	Layout string   // audio channel layout
+	Pix    string   // video pixel format
+	SAR    av.Ratio // video sample aspect ratio
 }
 
 func (o *Opts) Flags() *flag.FlagSet {
@@ -46,9 +67,27 @@ func (o *Opts) Flags() *flag.FlagSet {
 	fs.TextVar(&o.Aod, "aod", o.Aod, "first audio offset duration")
 	fs.TextVar(&o.Dur, "dur", o.Dur, "limit output duration")
 	fs.TextVar(&o.Fps, "fps", o.Fps, "video frame rate")
+	fs.Float64Var(&o.Tempo, "tempo", o.Tempo, "audio speed factor")
 	fs.TextVar(&o.Dim, "dim", o.Dim, "scale to output dimension")
 	fs.IntVar(&o.Rot, "rot", o.Rot, "rotate by degrees")
 	fs.BoolVar(&o.Yes, "yes", o.Yes, "override existing files")
+	fs.TextVar(&o.VSel, "vsel", o.VSel, "video stream selector")
+	fs.TextVar(&o.ASel, "asel", o.ASel, "audio stream selector")
+	fs.TextVar(&o.Trans, "trans", o.Trans, "default transition between clips")
+	if o.Joins == nil {
+		o.Joins = make(Joins)
+	}
+	fs.Var(o.Joins, "join", "transition override for a join")
+	o.Text.AddFlags(fs)
+	if o.Meta.Tags == nil {
+		o.Meta.Tags = make(Tags)
+	}
+	fs.Var(o.Meta.Tags, "meta", "metadata tag for the output")
+	fs.IntVar(&o.Out.Rate, "arate", o.Out.Rate, "output audio sample rate")
+	fs.StringVar(&o.Out.Sample, "afmt", o.Out.Sample, "output audio sample format")
+	fs.StringVar(&o.Out.Layout, "layout", o.Out.Layout, "output audio channel layout")
+	fs.StringVar(&o.Out.Pix, "pix", o.Out.Pix, "output video pixel format")
+	fs.TextVar(&o.Out.SAR, "sar", o.Out.SAR, "output video sample aspect ratio")
 	return fs
 }
 
@@ -0,0 +1,21 @@
+package ffm
+
+import (
+	"os/exec"
+)
+
+// GenLumaCmd returns a command that prints the frame times and average luma of the video stream
+// spec of a media file to stdout. The spec is a ffmpeg stream specifier like "v" or "v:1".
+//
+// The output has two lines per frame:
+//
+//	frame:0    pts:0       pts_time:0
+//	lavfi.signalstats.YAVG=16.000
+func GenLumaCmd(path, spec string) *exec.Cmd {
+	return Def().Cmd("ffmpeg", DefLog, Args(
+		"-i", path, // path to video file
+		"-map", "0:"+spec, // select only the video stream
+		"-vf", "signalstats,metadata=mode=print:key=lavfi.signalstats.YAVG:file=-",
+		"-f", "null", "-", // discard the output
+	))
+}
@@ -0,0 +1,190 @@
+package ffm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// ClapMark is the duration of the clap chapter that marks a clap at the end of the output.
+var ClapMark = av.S
+
+// Meta holds global tags and chapters that are muxed into or probed from media containers.
+type Meta struct {
+	Tags     Tags      `json:"tags,omitempty"`
+	Chapters []Chapter `json:"chapters,omitempty"`
+}
+
+// Chapter is a titled time span in a media container.
+type Chapter struct {
+	Start av.Dur `json:"start"`
+	End   av.Dur `json:"end"`
+	Title string `json:"title,omitempty"`
+}
+
+// Tags is a map of metadata tags.
+// It implements flag value for repeatable flags in the format key=value.
+type Tags map[string]string
+
+func (ts Tags) String() string {
+	var b strings.Builder
+	for i, k := range ts.keys() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", k, ts[k])
+	}
+	return b.String()
+}
+
+func (ts Tags) Set(str string) error {
+	k, v, ok := strings.Cut(str, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("invalid tag %s", str)
+	}
+	ts[k] = v
+	return nil
+}
+
+func (ts Tags) keys() []string {
+	keys := make([]string, 0, len(ts))
+	for k := range ts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Zero returns whether m has no tags or chapters.
+func (m *Meta) Zero() bool { return m == nil || len(m.Tags) == 0 && len(m.Chapters) == 0 }
+
+// WriteTo writes m in the ffmetadata format to w.
+func (m *Meta) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, k := range m.Tags.keys() {
+		fmt.Fprintf(&b, "%s=%s\n", metaEsc(k), metaEsc(m.Tags[k]))
+	}
+	for _, c := range m.Chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\n",
+			c.Start/(av.S/1000), c.End/(av.S/1000))
+		if c.Title != "" {
+			fmt.Fprintf(&b, "title=%s\n", metaEsc(c.Title))
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+var metaEscaper = strings.NewReplacer(
+	"\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n",
+)
+
+func metaEsc(s string) string { return metaEscaper.Replace(s) }
+
+// Meta returns the format tags and chapters of the probe result.
+func (nfo *Info) Meta() *Meta {
+	m := &Meta{Tags: make(Tags)}
+	for k, v := range nfo.Format.Obj("tags") {
+		if s, ok := v.(string); ok {
+			m.Tags[strings.ToLower(k)] = s
+		}
+	}
+	for _, c := range nfo.Chapters {
+		m.Chapters = append(m.Chapters, Chapter{
+			Start: c.Dur("start_time"),
+			End:   c.Dur("end_time"),
+			Title: c.Obj("tags").Str("title"),
+		})
+	}
+	return m
+}
+
+// ConcatMeta returns the metadata for the concatenated output of videos or audios.
+// It contains the configured tags, tags for the credits, a chapter for each segment and for the
+// clap point if Opts.Clap is set.
+func (o *Opts) ConcatMeta(videos, audios []*Info) *Meta {
+	m := &Meta{Tags: make(Tags)}
+	c := o.Text.Credits
+	for k, v := range map[string]string{
+		"title":     c.Title,
+		"artist":    c.Artist,
+		"composer":  c.Dubber,
+		"copyright": c.License,
+	} {
+		if v != "" {
+			m.Tags[k] = v
+		}
+	}
+	for k, v := range o.Meta.Tags {
+		m.Tags[k] = v
+	}
+	nfos, off := videos, o.Vod
+	if len(nfos) == 0 {
+		nfos, off = audios, o.Aod
+	}
+	start := o.Text.Intro
+	if !o.Text.Cards() {
+		start = 0
+	}
+	end := o.Dur
+	if end == 0 {
+		end = o.Length(nfos, off)
+	}
+	end += start
+	if len(nfos) > 1 {
+		for i, nfo := range nfos {
+			c := Chapter{Start: start, Title: nfo.File()}
+			start += nfo.Format.Dur("duration") - off - o.Join(i+1).Dur
+			off = 0
+			c.End = start
+			if i == len(nfos)-1 || c.End > end {
+				c.End = end
+			}
+			if c.Start >= end {
+				break
+			}
+			m.Chapters = append(m.Chapters, c)
+		}
+	}
+	if o.Clap > 0 {
+		at, total := o.Clap+o.Text.Intro, end+o.Text.Outro
+		if !o.Text.Cards() {
+			at, total = o.Clap, end
+		}
+		// the clap chapter spans to the end of the output including the outro card,
+		// an end clap without outro card is marked by a short chapter before it
+		c := Chapter{Start: at, End: total, Title: "clap"}
+		if c.End <= c.Start {
+			c.Start, c.End = at-ClapMark, at
+			if c.Start < 0 {
+				c.Start = 0
+			}
+		}
+		m.Chapters = append(m.Chapters, c)
+	}
+	return m
+}
+
+// writeMeta writes the metadata to a temp file and returns the input arguments and a cleanup func.
+func (o *Opts) writeMeta(m *Meta) ([]string, func(), error) {
+	f, err := os.CreateTemp("", "qnpdub*.txt")
+	if err != nil {
+		return nil, nil, err
+	}
+	done := func() { os.Remove(f.Name()) }
+	_, err = m.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	args := Args("-f", "ffmetadata", "-i", f.Name(), "-map_metadata", "0", "-map_chapters", "0")
+	return args, done, nil
+}
@@ -5,16 +5,23 @@ import (
 	"io"
 	"os/exec"
 
+	"github.com/mb0/qnpdub/av"
 	"github.com/mb0/qnpdub/av/pcm"
 )
 
 // GenPCMCmd returns a command to generate a waveform for a audio or video file.
 func GenPCMCmd(path, dest string, f pcm.Format) *exec.Cmd {
+	return GenStreamPCMCmd(path, "a", dest, f)
+}
+
+// GenStreamPCMCmd returns a command to generate a waveform for the stream spec of a media file.
+// The spec is a ffmpeg stream specifier like "a", "a:1" or the absolute stream index.
+func GenStreamPCMCmd(path, spec, dest string, f pcm.Format) *exec.Cmd {
 	return Def().Cmd("ffmpeg", Args(
 		"-i", path, // path to audio or video file
 		"-ac", "1", // set the number of audio channels
 		"-af", fmt.Sprintf("aresample=%d", f.Rate.Num),
-		"-map", "0:a", // select only the audio channel
+		"-map", "0:"+spec, // select only the audio stream
 		"-c:a", f.PCM.String(), // convert audio to pcm format
 		"-f", "data", dest, // output as data to dest
 	))
@@ -26,3 +33,20 @@ func GenPCMInto(path string, into io.Writer, f pcm.Format) error {
 	cmd.Stdout = into
 	return cmd.Run()
 }
+
+// GenPCMWindowInto generates the waveform for a time window of the stream spec of the media file at
+// path into the given writer. It is used to decode short windows at the source rate.
+func GenPCMWindowInto(path, spec string, start, dur av.Dur, into io.Writer, f pcm.Format) error {
+	cmd := Def().Cmd("ffmpeg", DefLog, Args(
+		"-ss", start.String(), // seek to the window start
+		"-i", path,
+		"-t", dur.String(), // decode only the window
+		"-ac", "1",
+		"-map", "0:"+spec,
+		"-c:a", f.PCM.String(),
+		"-ar", fmt.Sprint(f.Rate.Num),
+		"-f", "data", "-",
+	))
+	cmd.Stdout = into
+	return cmd.Run()
+}
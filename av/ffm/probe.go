@@ -25,7 +25,7 @@ func (o *Opts) Probe(path string) (*Info, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ffprobe %q: %w\n%s-%s", path, err, out, errb.String())
 	}
-	res := Info{Path: path}
+	res := Info{Path: path, VSel: o.VSel, ASel: o.ASel}
 	err = json.Unmarshal(out, &res)
 	if err != nil {
 		return nil, fmt.Errorf("ffprobe %q: %w", path, err)
@@ -49,20 +49,44 @@ func (o *Opts) ProbeAll(paths ...string) ([]*Info, error) {
 // Info contains the probe result.
 // See the various obj getter methods for known field names.
 type Info struct {
-	Path    string
-	Format  Obj  `json:"format"`
-	Streams Objs `json:"streams"`
+	Path     string
+	Format   Obj  `json:"format"`
+	Streams  Objs `json:"streams"`
+	Chapters Objs `json:"chapters"`
+	VSel     Sel  `json:"-"` // video stream selector
+	ASel     Sel  `json:"-"` // audio stream selector
 }
 
 // File returns the filename without the directory or an empty string.
 func (nfo *Info) File() string { _, n := filepath.Split(nfo.Path); return n }
 func (nfo *Info) Dir() string  { d, _ := filepath.Split(nfo.Path); return d }
 
-// Video returns the first video stream obj or nil.
-func (nfo *Info) Video() Obj { return nfo.Streams.First("codec_type", "video") }
+// Video returns the selected or first video stream obj or nil.
+func (nfo *Info) Video() Obj { return nfo.Streams.Select("video", nfo.VSel) }
 
-// Audio returns the first audio stream obj or nil.
-func (nfo *Info) Audio() Obj { return nfo.Streams.First("codec_type", "audio") }
+// VideoDur returns the duration of the selected video stream or of the container if unknown.
+func (nfo *Info) VideoDur() av.Dur {
+	if d := nfo.Video().Dur("duration"); d > 0 {
+		return d
+	}
+	return nfo.Format.Dur("duration")
+}
+
+// Audio returns the selected or first audio stream obj or nil.
+func (nfo *Info) Audio() Obj { return nfo.Streams.Select("audio", nfo.ASel) }
+
+// Spec returns the stream specifier for the selected video or audio stream or an empty string.
+// The default streams return the empty specifier.
+func (nfo *Info) Spec(kind string) string {
+	s, o := nfo.VSel, nfo.Video()
+	if kind == "audio" {
+		s, o = nfo.ASel, nfo.Audio()
+	}
+	if s.Zero() || o == nil {
+		return ""
+	}
+	return strconv.FormatInt(o.Int("index"), 10)
+}
 
 func Paths(nfos []*Info) []string {
 	res := make([]string, 0, len(nfos))
@@ -111,7 +135,8 @@ func (o Obj) Int(key string) (n int64) {
 }
 
 // Dur returns a duration value of field with key or 0.
-// Known duration fields are start_time, duration in both format and stream.
+// Known duration fields are start_time, duration in both format and stream, and start_time,
+// end_time in chapters.
 func (o Obj) Dur(key string) (d av.Dur) {
 	if s := o.Str(key); s != "" {
 		d, _ = av.ParseDur(s)
@@ -138,7 +163,8 @@ func (o Obj) Ratio(key string) (r av.Ratio) {
 }
 
 // Obj returns an Obj value of field with key or a nil obj.
-// Known obj fields besides format are tags in format and streams, and disposition in streams.
+// Known obj fields besides format are tags in format, streams and chapters, and disposition in
+// streams.
 func (o Obj) Obj(key string) Obj {
 	m, _ := o[key].(map[string]any)
 	return Obj(m)
@@ -0,0 +1,46 @@
+package ffm
+
+import "testing"
+
+func TestSelect(t *testing.T) {
+	nfo := &Info{Streams: Objs{
+		{"index": 0.0, "codec_type": "video"},
+		{"index": 1.0, "codec_type": "audio", "channel_layout": "stereo",
+			"tags": map[string]any{"title": "game", "language": "eng"}},
+		{"index": 2.0, "codec_type": "audio", "channel_layout": "stereo",
+			"tags": map[string]any{"title": "music"}},
+		{"index": 3.0, "codec_type": "audio", "channel_layout": "mono",
+			"tags": map[string]any{"title": "mic", "language": "deu"}},
+	}}
+	tests := []struct {
+		sel  string
+		want string
+	}{
+		{"", ""},
+		{"0", ""},
+		{"1", "2"},
+		{"title=mic", "3"},
+		{"lang=eng", "1"},
+		{"layout=stereo,1", "2"},
+		{"layout=mono", "3"},
+		{"lang=fra", "-"},
+	}
+	for _, test := range tests {
+		s, err := ParseSel(test.sel)
+		if err != nil {
+			t.Errorf("parse sel %s: %v", test.sel, err)
+			continue
+		}
+		nfo.ASel = s
+		got := nfo.Spec("audio")
+		if nfo.Audio() == nil {
+			got = "-"
+		}
+		if got != test.want {
+			t.Errorf("sel %s got spec %q want %q", test.sel, got, test.want)
+		}
+	}
+	if _, err := ParseSel("chan=2"); err == nil {
+		t.Errorf("parse sel want error")
+	}
+}
@@ -0,0 +1,88 @@
+package ffm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sel selects a stream of one kind by tags and index. The zero value selects the first stream.
+type Sel struct {
+	Idx    int    // index among matching streams of that kind
+	Lang   string // language tag
+	Title  string // title tag
+	Layout string // audio channel layout
+}
+
+// ParseSel parses a comma separated list of an index and the keys lang, title and layout.
+// For example: "1", "lang=eng", "title=mic" or "layout=stereo,1".
+func ParseSel(str string) (s Sel, err error) {
+	if str == "" {
+		return s, nil
+	}
+	for _, part := range strings.Split(str, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			s.Idx, err = strconv.Atoi(part)
+			if err != nil || s.Idx < 0 {
+				return s, fmt.Errorf("invalid stream index %s", part)
+			}
+			continue
+		}
+		switch k {
+		case "lang":
+			s.Lang = v
+		case "title":
+			s.Title = v
+		case "layout":
+			s.Layout = v
+		default:
+			return s, fmt.Errorf("invalid stream selector %s", part)
+		}
+	}
+	return s, nil
+}
+
+// Zero returns whether s selects the first stream.
+func (s Sel) Zero() bool { return s == Sel{} }
+
+func (s Sel) String() string {
+	var res []string
+	for _, kv := range [][2]string{{"lang", s.Lang}, {"title", s.Title}, {"layout", s.Layout}} {
+		if kv[1] != "" {
+			res = append(res, kv[0]+"="+kv[1])
+		}
+	}
+	if s.Idx != 0 || len(res) == 0 {
+		res = append(res, strconv.Itoa(s.Idx))
+	}
+	return strings.Join(res, ",")
+}
+func (s Sel) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+func (s *Sel) UnmarshalText(b []byte) (err error) {
+	*s, err = ParseSel(string(b))
+	return err
+}
+
+// Match returns whether the stream o matches the tags of s.
+func (s Sel) Match(o Obj) bool {
+	tags := o.Obj("tags")
+	return (s.Lang == "" || tags.Str("language") == s.Lang) &&
+		(s.Title == "" || tags.Str("title") == s.Title) &&
+		(s.Layout == "" || layout(o) == s.Layout)
+}
+
+// Select returns the stream with codec type kind selected by s or nil.
+func (os Objs) Select(kind string, s Sel) Obj {
+	idx := 0
+	for _, o := range os {
+		if o["codec_type"] != kind || !s.Match(o) {
+			continue
+		}
+		if idx == s.Idx {
+			return o
+		}
+		idx++
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package ffm
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// Credits holds information about the original work and the dub.
+type Credits struct {
+	Title      string `json:"title,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Dubber     string `json:"dubber,omitempty"`
+	Instrument string `json:"instrument,omitempty"`
+	License    string `json:"license,omitempty"`
+}
+
+// Zero returns whether c has no information.
+func (c Credits) Zero() bool { return c == Credits{} }
+
+// Work returns the title and artist of the original work.
+func (c Credits) Work() string {
+	if c.Artist == "" {
+		return c.Title
+	}
+	if c.Title == "" {
+		return c.Artist
+	}
+	return fmt.Sprintf("%s by %s", c.Title, c.Artist)
+}
+
+// Dub returns the dubber and instrument.
+func (c Credits) Dub() string {
+	switch {
+	case c.Dubber == "":
+		return c.Instrument
+	case c.Instrument == "":
+		return fmt.Sprintf("dubbed by %s", c.Dubber)
+	}
+	return fmt.Sprintf("dubbed by %s on %s", c.Dubber, c.Instrument)
+}
+
+// Intro returns the text for the intro card.
+func (c Credits) Intro() string { return lines(c.Work(), c.Dub()) }
+
+// Outro returns the text for the outro card.
+func (c Credits) Outro() string { return lines(c.Work(), c.Dub(), c.License) }
+
+// Lower returns the text for the lower-third overlay.
+func (c Credits) Lower() string { return lines(c.Work(), c.Dub()) }
+
+func lines(ls ...string) string {
+	res := ls[:0]
+	for _, l := range ls {
+		if l != "" {
+			res = append(res, l)
+		}
+	}
+	return strings.Join(res, "\n")
+}
+
+// Titles configures title cards and lower-third overlays rendered with drawtext.
+type Titles struct {
+	Credits
+	Font  string // font file or fontconfig pattern
+	Size  int    // font size in pixels
+	Color string // font color
+	Pos   string // lower-third position, see TextPos
+	Intro av.Dur // intro card duration
+	Outro av.Dur // outro card duration
+	Lower av.Dur // lower-third duration at the start of the first clip
+	Fade  av.Dur // fade in and out duration
+	dir   string // text file directory
+}
+
+// TextPos maps lower-third position names to drawtext x and y expressions.
+var TextPos = map[string][2]string{
+	"left":      {"w/20", "h-th-h/10"},
+	"center":    {"(w-tw)/2", "h-th-h/10"},
+	"right":     {"w-tw-w/20", "h-th-h/10"},
+	"top-left":  {"w/20", "h/10"},
+	"top":       {"(w-tw)/2", "h/10"},
+	"top-right": {"w-tw-w/20", "h/10"},
+}
+
+// Cards returns whether intro or outro cards are configured.
+func (t *Titles) Cards() bool { return !t.Credits.Zero() && (t.Intro > 0 || t.Outro > 0) }
+
+// On returns whether any title cards or overlays are configured.
+func (t *Titles) On() bool { return !t.Credits.Zero() && (t.Cards() || t.Lower > 0) }
+
+// Len returns the added duration of the intro and outro cards.
+func (t *Titles) Len() av.Dur {
+	if !t.Cards() {
+		return 0
+	}
+	return t.Intro + t.Outro
+}
+
+// AddFlags adds the title flags to fs.
+func (t *Titles) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&t.Title, "title", t.Title, "original title")
+	fs.StringVar(&t.Artist, "artist", t.Artist, "original artist")
+	fs.StringVar(&t.Dubber, "dubber", t.Dubber, "dubber name")
+	fs.StringVar(&t.Instrument, "instrument", t.Instrument, "dub instrument")
+	fs.StringVar(&t.License, "license", t.License, "license note")
+	fs.StringVar(&t.Font, "font", t.Font, "title font file or pattern")
+	fs.IntVar(&t.Size, "fontsize", t.Size, "title font size")
+	fs.StringVar(&t.Color, "fontcolor", t.Color, "title font color")
+	fs.StringVar(&t.Pos, "pos", t.Pos, "lower-third position")
+	fs.TextVar(&t.Intro, "intro", t.Intro, "intro card duration")
+	fs.TextVar(&t.Outro, "outro", t.Outro, "outro card duration")
+	fs.TextVar(&t.Lower, "lower", t.Lower, "lower-third duration")
+	fs.TextVar(&t.Fade, "fade", t.Fade, "title fade duration")
+}
+
+// writeFiles writes the title texts to files in a new temp directory and returns a cleanup func.
+// We use text files to avoid the multiple levels of escaping for drawtext text arguments.
+func (t *Titles) writeFiles() (func(), error) {
+	if _, ok := TextPos[t.Pos]; !ok && t.Pos != "" {
+		return nil, fmt.Errorf("invalid title position %q", t.Pos)
+	}
+	dir, err := os.MkdirTemp("", "qnpdub")
+	if err != nil {
+		return nil, err
+	}
+	done := func() { os.RemoveAll(dir); t.dir = "" }
+	t.dir = dir
+	for _, f := range [][2]string{
+		{"intro", t.Credits.Intro()},
+		{"outro", t.Credits.Outro()},
+		{"lower", t.Credits.Lower()},
+	} {
+		err = os.WriteFile(t.file(f[0]), []byte(f[1]), 0644)
+		if err != nil {
+			done()
+			return nil, err
+		}
+	}
+	return done, nil
+}
+
+func (t *Titles) file(name string) string {
+	return filepath.Join(t.dir, name+".txt")
+}
+
+// drawtext writes a drawtext filter for the text file name with position x and y.
+func (t *Titles) drawtext(fs *strings.Builder, name, x, y string) {
+	fmt.Fprintf(fs, "drawtext=textfile=%s:expansion=none", t.file(name))
+	if t.Font != "" {
+		if strings.ContainsRune(t.Font, '/') {
+			fmt.Fprintf(fs, ":fontfile=%s", t.Font)
+		} else {
+			fmt.Fprintf(fs, ":font=%s", t.Font)
+		}
+	}
+	size, color := t.Size, t.Color
+	if size == 0 {
+		size = 48
+	}
+	if color == "" {
+		color = "white"
+	}
+	fmt.Fprintf(fs, ":fontsize=%d:fontcolor=%s:x=%s:y=%s", size, color, x, y)
+}
+
+// card writes a title card source with the given name and duration labeled out.
+func (t *Titles) card(fs *strings.Builder, name string, d av.Dur, w, h int, fps av.Rate, f Format, out string) {
+	fmt.Fprintf(fs, "color=c=black:s=%dx%d:d=%s", w, h, d.Secs())
+	if fps.Den != 0 {
+		fmt.Fprintf(fs, ":r=%s", fps)
+	}
+	if f.Pix != "" {
+		fmt.Fprintf(fs, ", format=%s", f.Pix)
+	}
+	if !f.SAR.Zero() {
+		fmt.Fprintf(fs, ", setsar=%d/%d", f.SAR.W, f.SAR.H)
+	}
+	fs.WriteString(", ")
+	t.drawtext(fs, name, "(w-tw)/2", "(h-th)/2")
+	if t.Fade > 0 {
+		fmt.Fprintf(fs, ", fade=t=in:d=%s, fade=t=out:st=%s:d=%s",
+			t.Fade.Secs(), (d - t.Fade).Secs(), t.Fade.Secs())
+	}
+	fmt.Fprintf(fs, " %s;\n", out)
+}
+
+// video writes the filters adding the overlay and cards to the video stream in labeled out.
+func (t *Titles) video(fs *strings.Builder, in, out string, dur av.Dur, w, h int, fps av.Rate, f Format) {
+	fmt.Fprintf(fs, "%s ", in)
+	if dur > 0 {
+		fmt.Fprintf(fs, "trim=duration=%s, ", dur.Secs())
+	}
+	if t.Lower > 0 {
+		pos, ok := TextPos[t.Pos]
+		if !ok {
+			pos = TextPos["left"]
+		}
+		t.drawtext(fs, "lower", pos[0], pos[1])
+		fmt.Fprintf(fs, ":enable='between(t,0,%s)'", t.Lower.Secs())
+		if fd := t.Fade; fd > 0 {
+			fmt.Fprintf(fs, ":alpha='if(lt(t,%s),t/%[1]s,if(gt(t,%[2]s),(%[3]s-t)/%[1]s,1))'",
+				fd.Secs(), (t.Lower - fd).Secs(), t.Lower.Secs())
+		}
+	} else {
+		fs.WriteString("null")
+	}
+	if !t.Cards() {
+		fmt.Fprintf(fs, " %s\n", out)
+		return
+	}
+	fmt.Fprintf(fs, " [vmain];\n")
+	t.cards(fs, "v", out, func(name string, d av.Dur, out string) {
+		t.card(fs, name, d, w, h, fps, f, out)
+	})
+}
+
+// audio writes the filters adding silence for the cards to the audio stream in labeled out.
+func (t *Titles) audio(fs *strings.Builder, in, out string, dur av.Dur, f Format) {
+	fmt.Fprintf(fs, "%s ", in)
+	if dur > 0 {
+		fmt.Fprintf(fs, "atrim=duration=%s", dur.Secs())
+	} else {
+		fs.WriteString("anull")
+	}
+	if !t.Cards() {
+		fmt.Fprintf(fs, " %s\n", out)
+		return
+	}
+	fmt.Fprintf(fs, " [amain];\n")
+	t.cards(fs, "a", out, func(name string, d av.Dur, out string) {
+		fmt.Fprintf(fs, "anullsrc=d=%s", d.Secs())
+		if f.Rate != 0 {
+			fmt.Fprintf(fs, ":r=%d", f.Rate)
+		}
+		if f.Layout != "" {
+			fmt.Fprintf(fs, ":cl=%s", f.Layout)
+		}
+		fmt.Fprintf(fs, " %s;\n", out)
+	})
+}
+
+// cards writes the intro and outro sources using src and concatenates them with main to out.
+func (t *Titles) cards(fs *strings.Builder, kind, out string, src func(string, av.Dur, string)) {
+	v, a := 1, 0
+	if kind == "a" {
+		v, a = 0, 1
+	}
+	labels := []string{"[" + kind + "main]"}
+	if t.Intro > 0 {
+		l := "[" + kind + "intro]"
+		src("intro", t.Intro, l)
+		labels = append([]string{l}, labels...)
+	}
+	if t.Outro > 0 {
+		l := "[" + kind + "outro]"
+		src("outro", t.Outro, l)
+		labels = append(labels, l)
+	}
+	fmt.Fprintf(fs, "%s concat=n=%d:v=%d:a=%d %s\n", strings.Join(labels, " "), len(labels), v, a, out)
+}
+
+// outSize returns the output frame size for video stream v with rotation and scaling applied.
+func (o *Opts) outSize(v Obj) (w, h int) {
+	w, h = int(v.Int("width")), int(v.Int("height"))
+	if (o.Rot/90)%2 == 1 {
+		w, h = h, w
+	}
+	if o.Dim.Zero() || w == 0 || h == 0 {
+		return w, h
+	}
+	dw, dh := o.Dim.W, o.Dim.H
+	if dw < 0 && dh > 0 {
+		dw = fitDim(dh*w/h, -dw)
+	} else if dh < 0 && dw > 0 {
+		dh = fitDim(dw*h/w, -dh)
+	}
+	return dw, dh
+}
+
+func fitDim(n, div int) int {
+	if div > 1 {
+		n = (n + div/2) / div * div
+	}
+	return n
+}
@@ -0,0 +1,185 @@
+package ffm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// Trans describes the transition used to join two clips. The zero value is a hard cut.
+type Trans struct {
+	Name string // xfade transition name like fade, dissolve or wipeleft
+	Dur  av.Dur // overlap of both clips
+}
+
+// ParseTrans parses a transition in the format name:dur, dur or the names cut and none.
+func ParseTrans(str string) (t Trans, err error) {
+	if str == "" || str == "cut" || str == "none" {
+		return t, nil
+	}
+	name, dur, ok := strings.Cut(str, ":")
+	if !ok {
+		if d, err := av.ParseDur(str); err == nil {
+			return Trans{Name: "fade", Dur: d}, nil
+		}
+		return t, fmt.Errorf("invalid transition %s", str)
+	}
+	t.Name = name
+	t.Dur, err = av.ParseDur(dur)
+	if err != nil || t.Dur < 0 {
+		return t, fmt.Errorf("invalid transition %s", str)
+	}
+	return t, nil
+}
+
+// Cut returns whether t is a hard cut.
+func (t Trans) Cut() bool { return t.Dur <= 0 }
+
+func (t Trans) String() string {
+	if t.Cut() {
+		return "cut"
+	}
+	name := t.Name
+	if name == "" {
+		name = "fade"
+	}
+	return fmt.Sprintf("%s:%s", name, t.Dur.Secs())
+}
+func (t Trans) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+func (t *Trans) UnmarshalText(b []byte) (err error) {
+	*t, err = ParseTrans(string(b))
+	return err
+}
+
+// Joins maps join numbers to transitions, that override the default transition.
+// Join n is the transition between the clips n and n+1 starting at one.
+// It implements flag value for repeatable flags in the format n=name:dur.
+type Joins map[int]Trans
+
+func (js Joins) String() string {
+	keys := make([]int, 0, len(js))
+	for k := range js {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%d=%s", k, js[k])
+	}
+	return b.String()
+}
+
+func (js Joins) Set(str string) error {
+	for _, part := range strings.Split(str, ",") {
+		num, trans, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid join %s", part)
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid join number %s", part)
+		}
+		js[n], err = ParseTrans(trans)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Join returns the transition for the join n from the overrides or the default.
+func (o *Opts) Join(n int) Trans {
+	if t, ok := o.Joins[n]; ok {
+		return t
+	}
+	return o.Trans
+}
+
+// Overlap returns the accumulated transition durations for the first n joins.
+func (o *Opts) Overlap(n int) (sum av.Dur) {
+	for i := 1; i <= n; i++ {
+		sum += o.Join(i).Dur
+	}
+	return sum
+}
+
+// Length returns the output duration of the joined clips with the first clip trimmed by off.
+func (o *Opts) Length(nfos []*Info, off av.Dur) av.Dur {
+	if len(nfos) == 0 {
+		return 0
+	}
+	return SumDur(nfos) - off - o.Overlap(len(nfos)-1)
+}
+
+// hasTrans returns whether any of the first n joins is not a hard cut.
+func (o *Opts) hasTrans(n int) bool {
+	for i := 1; i <= n; i++ {
+		if !o.Join(i).Cut() {
+			return true
+		}
+	}
+	return false
+}
+
+// join writes the filters joining the streams labeled with kind and numbers into out and end.
+// It uses a single concat filter for hard cuts only and otherwise chains each join.
+func (o *Opts) join(fs *strings.Builder, kind byte, nfos []*Info, off av.Dur, out, end string) {
+	n := len(nfos)
+	v, a := 1, 0
+	if kind == 'a' {
+		v, a = 0, 1
+	}
+	if !o.hasTrans(n - 1) {
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(fs, "[%c%d] ", kind, i)
+		}
+		fmt.Fprintf(fs, "concat=n=%d:v=%d:a=%d %s%s", n, v, a, out, end)
+		return
+	}
+	last := fmt.Sprintf("[%c1]", kind)
+	// the xfade offset is relative to the joined video stream, which can be shorter than the
+	// container
+	l := nfos[0].VideoDur() - off
+	for i := 1; i < n; i++ {
+		t := o.Join(i)
+		next := fmt.Sprintf("[%cx%d]", kind, i)
+		if i == n-1 {
+			next = out
+		}
+		fmt.Fprintf(fs, "%s [%c%d] ", last, kind, i+1)
+		switch {
+		case t.Cut():
+			fmt.Fprintf(fs, "concat=n=2:v=%d:a=%d", v, a)
+		case kind == 'a':
+			fmt.Fprintf(fs, "acrossfade=d=%s", t.Dur.Secs())
+		default:
+			name := t.Name
+			if name == "" {
+				name = "fade"
+			}
+			fmt.Fprintf(fs, "xfade=transition=%s:duration=%s:offset=%s",
+				name, t.Dur.Secs(), (l - t.Dur).Secs())
+		}
+		if i < n-1 {
+			fmt.Fprintf(fs, " %s;\n", next)
+		} else {
+			fmt.Fprintf(fs, " %s%s", next, end)
+		}
+		l += nfos[i].VideoDur() - t.Dur
+		last = next
+	}
+}
+
+// SumDur returns the summed container durations of nfos.
+func SumDur(nfos []*Info) (sum av.Dur) {
+	for _, nfo := range nfos {
+		sum += nfo.Format.Dur("duration")
+	}
+	return sum
+}
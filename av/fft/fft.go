@@ -0,0 +1,78 @@
+// Package fft implements a radix-2 fast fourier transform for complex and real values.
+package fft
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// Size returns the smallest power of two that is greater or equal to n.
+func Size(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// FFT transforms x in place. The length of x must be a power of two.
+func FFT(x []complex128) { transform(x, false) }
+
+// IFFT transforms x back in place and scales the result by 1/len(x).
+func IFFT(x []complex128) {
+	transform(x, true)
+	s := complex(1/float64(len(x)), 0)
+	for i := range x {
+		x[i] *= s
+	}
+}
+
+// Real returns the transform of the real values vals zero padded to size n in buf.
+func Real(vals []float64, n int, buf []complex128) []complex128 {
+	if cap(buf) < n {
+		buf = make([]complex128, n)
+	}
+	buf = buf[:n]
+	for i := range buf {
+		if i < len(vals) {
+			buf[i] = complex(vals[i], 0)
+		} else {
+			buf[i] = 0
+		}
+	}
+	FFT(buf)
+	return buf
+}
+
+func transform(x []complex128, inv bool) {
+	n := len(x)
+	if n&(n-1) != 0 {
+		panic("fft: length is not a power of two")
+	}
+	// bit reversal permutation
+	shift := 64 - bits.Len(uint(n-1))
+	if n > 1 {
+		for i := range x {
+			j := int(bits.Reverse64(uint64(i)) >> shift)
+			if i < j {
+				x[i], x[j] = x[j], x[i]
+			}
+		}
+	}
+	sign := -1.0
+	if inv {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < half; k++ {
+				a, b := x[start+k], w*x[start+k+half]
+				x[start+k], x[start+k+half] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
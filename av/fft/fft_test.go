@@ -0,0 +1,36 @@
+package fft
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestFFT(t *testing.T) {
+	vals := []float64{1, 2, 0, -1, 3, 0.5, -2, 1, 0, 4}
+	n := Size(len(vals))
+	if n != 16 {
+		t.Fatalf("size got %d want 16", n)
+	}
+	got := Real(vals, n, nil)
+	// compare with the naive discrete fourier transform
+	for k := 0; k < n; k++ {
+		var want complex128
+		for i, v := range vals {
+			want += complex(v, 0) * cmplx.Rect(1, -2*math.Pi*float64(k*i)/float64(n))
+		}
+		if cmplx.Abs(got[k]-want) > 1e-9 {
+			t.Errorf("fft %d got %v want %v", k, got[k], want)
+		}
+	}
+	IFFT(got)
+	for i := 0; i < n; i++ {
+		var want float64
+		if i < len(vals) {
+			want = vals[i]
+		}
+		if math.Abs(real(got[i])-want) > 1e-9 || math.Abs(imag(got[i])) > 1e-9 {
+			t.Errorf("ifft %d got %v want %g", i, got[i], want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package flash detects visual sync markers in video streams.
+//
+// A flash, a phone-screen slate or a hand clap closing in front of the lens show up as a sudden
+// jump of the average frame luma. The detector finds the most prominent group of jumps near the
+// start or end of a video and reports the first frame after the first jump as clap.
+package flash
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/clap"
+	"github.com/mb0/qnpdub/av/ffm"
+)
+
+// Frame holds the presentation time and average luma of a video frame.
+type Frame struct {
+	Time av.Dur
+	Luma float64
+}
+
+// Detector is a helper for visual clap detection in video files.
+type Detector struct {
+	At   clap.At // clap marker at the start, end or both
+	Win  av.Dur  // search window at the scanned end
+	Trsh float64 // min jump in standard deviations of the frame luma differences
+	Sel  ffm.Sel // video stream selector
+}
+
+// Default returns a new detector that searches the last 30 seconds for a jump of at least
+// five standard deviations.
+func Default() *Detector {
+	return &Detector{Win: 30 * av.S, Trsh: 5}
+}
+
+// Load returns the frame lumas of the video file at path or an error.
+// It caches the luma statistics alongside the media file, if it does not exist.
+func (d *Detector) Load(path string) ([]Frame, error) {
+	dest := path + ".luma"
+	spec := "v:0"
+	if !d.Sel.Zero() {
+		nfo, err := ffm.Probe(path)
+		if err != nil {
+			return nil, err
+		}
+		nfo.VSel = d.Sel
+		if spec = nfo.Spec("video"); spec == "" {
+			return nil, fmt.Errorf("media %q has no video stream %s", path, d.Sel)
+		}
+		dest = fmt.Sprintf("%s.%s.luma", path, spec)
+	}
+	if f, err := os.Open(dest); err == nil {
+		defer f.Close()
+		return ParseLuma(f)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("media %q not found: %w", path, err)
+	}
+	out, err := ffm.GenLumaCmd(path, spec).Output()
+	if err != nil {
+		return nil, fmt.Errorf("luma gen failed: %w", err)
+	}
+	fs, err := ParseLuma(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(dest, out, 0644); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+const lumaKey = "lavfi.signalstats.YAVG="
+
+// ParseLuma parses the output of ffm.GenLumaCmd and returns the frames.
+func ParseLuma(r io.Reader) ([]Frame, error) {
+	var res []Frame
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "frame:") {
+			var f Frame
+			for _, fld := range strings.Fields(line) {
+				if strings.HasPrefix(fld, "pts_time:") {
+					t, err := strconv.ParseFloat(fld[9:], 64)
+					if err != nil {
+						return nil, fmt.Errorf("invalid frame time %q", line)
+					}
+					f.Time = av.Dur(math.Round(t * float64(av.S)))
+				}
+			}
+			res = append(res, f)
+		} else if strings.HasPrefix(line, lumaKey) && len(res) > 0 {
+			l, err := strconv.ParseFloat(line[len(lumaKey):], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid frame luma %q", line)
+			}
+			res[len(res)-1].Luma = l
+		}
+	}
+	return res, sc.Err()
+}
+
+// Match detects the visual claps in the video files at paths and returns the results in order.
+func (d *Detector) Match(paths ...string) ([]clap.Clap, error) {
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("needs at least two videos")
+	}
+	res := make([]clap.Clap, 0, len(paths))
+	var max av.Dur
+	for _, path := range paths {
+		fs, err := d.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		c, err := d.Detect(fs)
+		if err != nil {
+			return nil, fmt.Errorf("flash in %q: %w", path, err)
+		}
+		if c.Clap > max {
+			max = c.Clap
+		}
+		res = append(res, c)
+	}
+	for i, c := range res {
+		res[i].Off = max - c.Clap
+	}
+	return res, nil
+}
+
+// Detect returns the visual clap in frames at the configured marker or an error.
+// If both ends are configured the more prominent jump is used.
+func (d *Detector) Detect(fs []Frame) (clap.Clap, error) {
+	if d.At != clap.Both {
+		return d.DetectAt(fs, d.At)
+	}
+	end, err := d.DetectAt(fs, clap.End)
+	start, serr := d.DetectAt(fs, clap.Start)
+	if err != nil || serr == nil && start.Conf.Prom > end.Conf.Prom {
+		return start, serr
+	}
+	return end, nil
+}
+
+// DetectAt returns the visual clap in frames at the start or end or an error.
+// The clap is the first frame of the most prominent visual event in the search window.
+// Runner-up events are returned as candidates.
+func (d *Detector) DetectAt(fs []Frame, at clap.At) (clap.Clap, error) {
+	if len(fs) < 3 {
+		return clap.Clap{}, fmt.Errorf("not enough frames")
+	}
+	lo, hi := 1, len(fs)
+	switch at {
+	case clap.Start:
+		for hi > lo && fs[hi-1].Time-fs[0].Time > d.Win {
+			hi--
+		}
+	case clap.End:
+		for lo < hi && fs[len(fs)-1].Time-fs[lo].Time > d.Win {
+			lo++
+		}
+	default:
+		return clap.Clap{}, fmt.Errorf("invalid clap marker %s", at)
+	}
+	jumps := make([]jump, 0, hi-lo)
+	var mean, sqs float64
+	for i := lo; i < hi; i++ {
+		j := jump{i, math.Abs(fs[i].Luma - fs[i-1].Luma)}
+		jumps = append(jumps, j)
+		mean += j.diff
+		sqs += j.diff * j.diff
+	}
+	if len(jumps) < 2 {
+		return clap.Clap{}, fmt.Errorf("not enough frames in window")
+	}
+	n := float64(len(jumps))
+	mean /= n
+	sd := math.Sqrt(math.Max(sqs/n-mean*mean, 0))
+	if sd == 0 {
+		return clap.Clap{}, fmt.Errorf("no luma change")
+	}
+	// group significant jumps, because a flash or a closing hand both start and end with one
+	var evs []event
+	for _, j := range jumps {
+		z := (j.diff - mean) / sd
+		if z < d.Trsh {
+			continue
+		}
+		t := fs[j.idx].Time
+		if n := len(evs) - 1; n >= 0 && t-evs[n].last <= Group {
+			evs[n].last = t
+			evs[n].prom = math.Max(evs[n].prom, z)
+			continue
+		}
+		evs = append(evs, event{t, t, z})
+	}
+	if len(evs) == 0 {
+		return clap.Clap{}, fmt.Errorf("no luma jump above %g standard deviations", d.Trsh)
+	}
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].prom > evs[j].prom })
+	c := clap.Clap{At: at, Clap: evs[0].start, Conf: &clap.Conf{Prom: evs[0].prom}}
+	for _, ev := range evs[1:] {
+		if len(c.Cands) >= 3 {
+			break
+		}
+		c.Cands = append(c.Cands, ev.start)
+	}
+	return c, nil
+}
+
+// Group is the max duration between luma jumps that belong to one visual event.
+var Group = av.S
+
+// event is a group of luma jumps with the time of the first and last jump and max prominence.
+type event struct {
+	start, last av.Dur
+	prom        float64
+}
+
+// jump is the absolute luma difference of a frame to its predecessor.
+type jump struct {
+	idx  int
+	diff float64
+}
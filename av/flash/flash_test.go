@@ -0,0 +1,71 @@
+package flash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/clap"
+)
+
+func TestParseLuma(t *testing.T) {
+	out := `frame:0    pts:0       pts_time:0
+lavfi.signalstats.YAVG=16.000
+frame:1    pts:512     pts_time:0.04
+lavfi.signalstats.YAVG=120.5
+`
+	fs, err := ParseLuma(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Frame{{0, 16}, {av.S / 25, 120.5}}
+	if len(fs) != len(want) || fs[0] != want[0] || fs[1] != want[1] {
+		t.Errorf("parse luma got %v want %v", fs, want)
+	}
+}
+
+func TestDetectAt(t *testing.T) {
+	// 60s at 25fps with noisy luma, a flash at 5s and a closing hand at 55s
+	fs := make([]Frame, 60*25)
+	for i := range fs {
+		fs[i] = Frame{Time: av.Dur(i) * av.S / 25, Luma: 80 + float64(i%3)}
+	}
+	for i := 125; i < 128; i++ {
+		fs[i].Luma = 220
+	}
+	for i := 1375; i < 1390; i++ {
+		fs[i].Luma = 10
+	}
+	// a smaller scene change before the hand
+	for i := 1300; i < len(fs); i++ {
+		fs[i].Luma += 20
+	}
+	d := Default()
+	tests := []struct {
+		at   clap.At
+		want av.Dur
+	}{
+		{clap.Start, 5 * av.S},
+		{clap.End, 55 * av.S},
+		{clap.Both, 5 * av.S},
+	}
+	for _, test := range tests {
+		d.At = test.at
+		c, err := d.Detect(fs)
+		if err != nil {
+			t.Errorf("detect %s: %v", test.at, err)
+			continue
+		}
+		if c.Clap != test.want {
+			t.Errorf("detect %s got %s want %s", test.at, c.Clap, test.want)
+		}
+	}
+	d.At = clap.End
+	c, _ := d.Detect(fs)
+	if len(c.Cands) != 1 || c.Cands[0] != 52*av.S {
+		t.Errorf("detect cands got %v want [52s]", c.Cands)
+	}
+	if _, err := d.Detect(fs[:50]); err == nil {
+		t.Errorf("detect without jump want error")
+	}
+}
@@ -0,0 +1,167 @@
+// Package flux implements a spectral flux onset detector.
+//
+// The samples are split into overlapping hann windowed frames. The flux of a frame is the summed
+// rise of the log magnitude spectrum from the previous frame. Broadband transients like claps
+// have a high flux even over loud sustained music. Onsets are detected with the z-score algorithm
+// of package peak on the flux values.
+package flux
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/mb0/qnpdub/av/fft"
+	"github.com/mb0/qnpdub/peak"
+)
+
+// Scale converts flux values to the int16 range of the returned peaks.
+const Scale = 100
+
+// Detector is a spectral flux onset detector that can stand in for a int16 peak detector.
+type Detector struct {
+	Size, Hop int // frame size and hop in samples
+	det       *peak.Detector[float64]
+	win       []float64 // hann window
+	buf, tmp  []float64 // pending samples in time order and a swap buffer
+	pos       int       // sample offset of buf[0]
+	fed       bool      // whether buf continues a fed chunk
+	rev       bool      // whether chunks are fed backward
+	frame     int       // index of the next flux value fed to det
+	prev, cur []float64 // log magnitudes of the previous and current frame
+	has       bool      // whether prev holds a frame
+	spec      []complex128
+	pk        peak.Peaks[float64] // flux peak buffer
+	val       [1]float64          // flux value buffer
+}
+
+// New returns a new detector with frame size and hop in samples and the z-score threshold and
+// lag in frames. The frame size must be a power of two.
+func New(size, hop int, trsh float64, lag int) *Detector {
+	win := make([]float64, size)
+	for i := range win {
+		win[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+	return &Detector{Size: size, Hop: hop, win: win, det: peak.New[float64](0, trsh, lag, 2*lag)}
+}
+
+// Feed finds and returns onsets for a chunk of values at an offset, see FeedTo.
+func (d *Detector) Feed(off int, vals ...int16) peak.Peaks[int16] {
+	var r peak.Peaks[int16]
+	d.FeedTo(&r, off, vals)
+	return r
+}
+
+// FeedTo finds the onsets for a chunk of values at an offset and stores them in r.
+// The summary, signals and events hold the scaled flux values at the frame centers.
+// Frames continue across consecutive chunks in the feed direction and restart otherwise.
+// Backward detectors take frames from the end and keep the flux of each frame relative to the
+// frame before it in time, so both directions find the same onsets.
+func (d *Detector) FeedTo(r *peak.Peaks[int16], off int, vals []int16) {
+	end := off + len(vals)
+	if d.fed && (!d.rev && off != d.pos+len(d.buf) || d.rev && end != d.pos) {
+		d.buf, d.has = d.buf[:0], false
+	}
+	if d.rev {
+		// prepend the chunk to the pending samples that follow it in time
+		tmp := d.tmp[:0]
+		for _, v := range vals {
+			tmp = append(tmp, float64(v))
+		}
+		d.buf, d.tmp = append(tmp, d.buf...), d.buf
+		d.pos = off
+	} else {
+		if len(d.buf) == 0 {
+			d.pos = off
+		}
+		for _, v := range vals {
+			d.buf = append(d.buf, float64(v))
+		}
+	}
+	d.fed = true
+	*r = peak.Peaks[int16]{Off: off, Sigs: r.Sigs[:0], Events: r.Events[:0]}
+	for len(d.buf) >= d.Size {
+		start, frame := d.pos, d.buf[:d.Size]
+		if d.rev {
+			start = d.pos + len(d.buf) - d.Size
+			frame = d.buf[len(d.buf)-d.Size:]
+		}
+		if fl, ok := d.flux(frame); ok {
+			// the backward flux belongs to the later frame fed before
+			at := start + d.Size/2
+			if d.rev {
+				at += d.Hop
+			}
+			d.feed(r, at, fl)
+		}
+		if d.rev {
+			d.buf = d.buf[:len(d.buf)-d.Hop]
+		} else {
+			n := copy(d.buf, d.buf[d.Hop:])
+			d.buf = d.buf[:n]
+			d.pos += d.Hop
+		}
+	}
+	r.Mean, r.Vari = d.det.Mean*Scale, d.det.Vari*Scale*Scale
+}
+
+// feed feeds the flux value fl of the frame centered at at to the z-score detector and adds
+// onsets to r. The values are fed with consecutive frame indices in the feed direction.
+func (d *Detector) feed(r *peak.Peaks[int16], at int, fl float64) {
+	d.val[0] = fl
+	d.det.FeedTo(&d.pk, d.frame, d.val[:])
+	if d.rev {
+		d.frame--
+	} else {
+		d.frame++
+	}
+	v := int16(math.Min(fl*Scale, math.MaxInt16))
+	r.Show(at, v)
+	if len(d.pk.Sigs) > 0 {
+		r.Sigs = append(r.Sigs, peak.Sig[int16]{Idx: at, Val: v})
+		// consecutive onset frames form an event
+		r.NextEvent(at, d.Hop).Add(at, v, (fl-d.pk.Mean)*Scale)
+	}
+}
+
+// Reset reverts the detector to its initial condition for chunks fed forward or backward.
+func (d *Detector) Reset(rev bool) {
+	d.det.Reset(rev)
+	d.buf, d.pos, d.fed, d.rev, d.frame, d.has = d.buf[:0], 0, false, rev, 0, false
+}
+
+// flux returns the spectral flux between frame and the frame fed before it or false for the
+// first frame. The flux is the summed rise of the log magnitudes forward in time.
+func (d *Detector) flux(frame []float64) (float64, bool) {
+	if cap(d.spec) < d.Size {
+		d.spec = make([]complex128, d.Size)
+	}
+	d.spec = d.spec[:d.Size]
+	for i, v := range frame {
+		d.spec[i] = complex(v*d.win[i], 0)
+	}
+	fft.FFT(d.spec)
+	n := d.Size/2 + 1
+	if len(d.cur) != n {
+		d.prev, d.cur = make([]float64, n), make([]float64, n)
+	}
+	for i, c := range d.spec[:n] {
+		d.cur[i] = math.Log1p(cmplx.Abs(c) / float64(d.Size))
+	}
+	first := !d.has
+	d.prev, d.cur, d.has = d.cur, d.prev, true
+	if first {
+		return 0, false
+	}
+	// after the swap cur holds the frame fed before
+	var sum float64
+	for i, m := range d.prev {
+		diff := m - d.cur[i]
+		if d.rev {
+			diff = -diff
+		}
+		if diff > 0 {
+			sum += diff
+		}
+	}
+	return sum, true
+}
@@ -0,0 +1,103 @@
+package gen
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// Beep describes a sync signal that can be played at the start and end of a take.
+// It is defined in continuous time, so templates at different rates describe the same sound.
+type Beep struct {
+	Kind  string  `json:"kind"`  // chirp or mls
+	Dur   av.Dur  `json:"dur"`   // chirp duration
+	Lo    float64 `json:"lo"`    // chirp start frequency in hz
+	Hi    float64 `json:"hi"`    // chirp end frequency in hz
+	Order int     `json:"order"` // mls register length
+	Chips float64 `json:"chips"` // mls chips per second
+}
+
+// Beeps maps the beep kinds to their default settings. Both stay below 4khz, so they survive
+// the 8khz waveforms used for clap detection.
+var Beeps = map[string]Beep{
+	// a half second linear sweep from 500hz to 3.5khz
+	"chirp": {Kind: "chirp", Dur: av.S / 2, Lo: 500, Hi: 3500},
+	// a maximum length sequence of 2047 chips at 2000 chips per second
+	"mls": {Kind: "mls", Order: 11, Chips: 2000},
+}
+
+// ParseBeep returns the default beep of kind or an error.
+func ParseBeep(kind string) (Beep, error) {
+	b, ok := Beeps[kind]
+	if !ok {
+		return b, fmt.Errorf("invalid beep kind %q", kind)
+	}
+	return b, nil
+}
+
+// Template returns the samples of beep b at rate in the range of -1 to 1.
+func (b Beep) Template(rate av.Rate) []float64 {
+	r := float64(rate.Num) / float64(rate.Den)
+	if b.Kind == "mls" {
+		seq := MLS(b.Order)
+		n := int(float64(len(seq)) * r / b.Chips)
+		res := make([]float64, n)
+		for i := range res {
+			res[i] = float64(seq[int(float64(i)*b.Chips/r)])
+		}
+		return res
+	}
+	return Chirp(rate, b.Dur, b.Lo, b.Hi)
+}
+
+// Beep adds beep b with amplitude amp at time at.
+func (s *Signal) Beep(b Beep, at av.Dur, amp float64) *Signal {
+	off := s.Rate.Beats(at)
+	for i, v := range b.Template(s.Rate) {
+		if j := off + i; j >= 0 && j < len(s.Smpls) {
+			s.Smpls[j] += amp * v
+		}
+	}
+	return s
+}
+
+// Chirp returns a linear sweep from lo to hi hz over dur at rate with 5ms fades.
+func Chirp(rate av.Rate, dur av.Dur, lo, hi float64) []float64 {
+	r := float64(rate.Num) / float64(rate.Den)
+	res := make([]float64, rate.Beats(dur))
+	sec := float64(len(res)) / r
+	fade := r / 200
+	for i := range res {
+		t := float64(i) / r
+		g := math.Min(1, math.Min(float64(i), float64(len(res)-1-i))/fade)
+		res[i] = g * math.Sin(2*math.Pi*(lo*t+(hi-lo)*t*t/(2*sec)))
+	}
+	return res
+}
+
+// taps are the feedback taps of maximum length linear feedback shift registers by order.
+var taps = map[int][]int{
+	7: {7, 6}, 8: {8, 6, 5, 4}, 9: {9, 5}, 10: {10, 7}, 11: {11, 9}, 12: {12, 11, 10, 4},
+	13: {13, 12, 11, 8}, 14: {14, 13, 12, 2}, 15: {15, 14}, 16: {16, 15, 13, 4},
+}
+
+// MLS returns the maximum length sequence of order as values of -1 and 1 or nil for an
+// unsupported order from 7 to 16. The sequence has 2^order-1 values.
+func MLS(order int) []int8 {
+	tp, ok := taps[order]
+	if !ok {
+		return nil
+	}
+	res := make([]int8, 1<<order-1)
+	reg := uint32(1)
+	for i := range res {
+		var bit uint32
+		for _, t := range tp {
+			bit ^= reg >> (order - t)
+		}
+		res[i] = int8(2*(reg&1)) - 1
+		reg = reg>>1 | (bit&1)<<(order-1)
+	}
+	return res
+}
@@ -0,0 +1,143 @@
+// Package gen synthesizes waveforms with known content for offline tests.
+//
+// A signal is a buffer of float samples in the range of -1 to 1 that is built up by adding noise,
+// tones, music-like beds and clap impulses at known offsets. All randomness is seeded, so the same
+// calls always produce the same samples.
+package gen
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// Signal is a synthesized signal with samples in the range of -1 to 1.
+type Signal struct {
+	Rate  av.Rate
+	Smpls []float64
+	rnd   *rand.Rand
+}
+
+// New returns a silent signal of duration dur at rate with a random seed.
+func New(rate av.Rate, dur av.Dur, seed int64) *Signal {
+	return &Signal{Rate: rate, Smpls: make([]float64, rate.Beats(dur)), rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Dur returns the duration of the signal.
+func (s *Signal) Dur() av.Dur { return s.Rate.Dur(len(s.Smpls)) }
+
+// span returns the sample range of start and duration dur clipped to the signal.
+func (s *Signal) span(start, dur av.Dur) (int, int) {
+	a, b := s.Rate.Beats(start), s.Rate.Beats(start+dur)
+	if a < 0 {
+		a = 0
+	}
+	if b > len(s.Smpls) {
+		b = len(s.Smpls)
+	}
+	return a, b
+}
+
+// Noise adds white noise with amplitude amp to the whole signal.
+func (s *Signal) Noise(amp float64) *Signal {
+	for i := range s.Smpls {
+		s.Smpls[i] += amp * (2*s.rnd.Float64() - 1)
+	}
+	return s
+}
+
+// Tone adds a sine tone with frequency freq in hz and amplitude amp at start for duration dur.
+// The tone fades in and out over 5ms to avoid clicks.
+func (s *Signal) Tone(start, dur av.Dur, freq, amp float64) *Signal {
+	a, b := s.span(start, dur)
+	rate := float64(s.Rate.Num) / float64(s.Rate.Den)
+	fade := rate / 200
+	for i := a; i < b; i++ {
+		g := math.Min(1, math.Min(float64(i-a), float64(b-1-i))/fade)
+		s.Smpls[i] += amp * g * math.Sin(2*math.Pi*freq*float64(i-a)/rate)
+	}
+	return s
+}
+
+// Swell adds a sine tone with frequency freq in hz at start for duration dur, that rises linearly
+// from silence to amplitude amp and then stops.
+func (s *Signal) Swell(start, dur av.Dur, freq, amp float64) *Signal {
+	a, b := s.span(start, dur)
+	rate := float64(s.Rate.Num) / float64(s.Rate.Den)
+	for i := a; i < b; i++ {
+		g := float64(i-a+1) / float64(b-a)
+		s.Smpls[i] += amp * g * math.Sin(2*math.Pi*freq*float64(i-a)/rate)
+	}
+	return s
+}
+
+// Bed adds a music-like bed with amplitude amp to the whole signal. It plays a chord that changes
+// every bar and a kick drum on each beat at tempo bpm.
+func (s *Signal) Bed(bpm, amp float64) *Signal {
+	beat := av.Dur(60 / bpm * float64(av.S))
+	chords := [][]float64{{220, 277.2, 329.6}, {196, 246.9, 293.7}, {174.6, 220, 261.6}}
+	for i, t := 0, av.Dur(0); t < s.Dur(); i, t = i+1, t+beat {
+		if i%4 == 0 {
+			for _, f := range chords[i/4%len(chords)] {
+				s.Tone(t, 4*beat, f, amp/6)
+			}
+		}
+		s.kick(t, amp/2)
+	}
+	return s
+}
+
+// kick adds a kick drum with a falling pitch and a soft attack at start.
+func (s *Signal) kick(start av.Dur, amp float64) {
+	a, b := s.span(start, av.S/5)
+	rate := float64(s.Rate.Num) / float64(s.Rate.Den)
+	var ph float64
+	for i := a; i < b; i++ {
+		t := float64(i-a) / rate
+		ph += 2 * math.Pi * (50 + 100*math.Exp(-t*30)) / rate
+		g := math.Min(1, t*200) * math.Exp(-t*15)
+		s.Smpls[i] += amp * g * math.Sin(ph)
+	}
+}
+
+// Clap adds a clap impulse with amplitude amp at time at. The clap starts with its peak and
+// decays as noise over about 30ms. All claps have the same shape, so their peaks are at the same
+// sample relative to at in any signal with the same rate.
+func (s *Signal) Clap(at av.Dur, amp float64) *Signal {
+	a, b := s.span(at, 30*av.S/1000)
+	rate := float64(s.Rate.Num) / float64(s.Rate.Den)
+	rnd := rand.New(rand.NewSource(1))
+	for i := a; i < b; i++ {
+		v := 1.0
+		if i > a {
+			t := float64(i-a) / rate
+			v = .6 * (2*rnd.Float64() - 1) * math.Exp(-t*150)
+		}
+		s.Smpls[i] += amp * v
+	}
+	return s
+}
+
+// Claps adds clap impulses with amplitude amp at start plus each offset in offs.
+func (s *Signal) Claps(start av.Dur, amp float64, offs ...av.Dur) *Signal {
+	for _, off := range offs {
+		s.Clap(start+off, amp)
+	}
+	return s
+}
+
+// Samples returns the signal as 16 bit samples. Values outside the range of -1 to 1 are clipped.
+func (s *Signal) Samples() []int16 {
+	res := make([]int16, len(s.Smpls))
+	for i, v := range s.Smpls {
+		res[i] = int16(math.Max(-1, math.Min(1, v)) * math.MaxInt16)
+	}
+	return res
+}
+
+// Wave returns the signal as in-memory waveform with encoding p at the signal rate and path.
+func (s *Signal) Wave(p pcm.PCM, path string) *pcm.Buffer {
+	return pcm.Samples(pcm.Format{PCM: p, Rate: s.Rate}, path, s.Samples())
+}
@@ -0,0 +1,112 @@
+package gen
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+func TestSeed(t *testing.T) {
+	a := New(av.Hz(8000), 2*av.S, 1).Noise(.1).Bed(120, .3)
+	b := New(av.Hz(8000), 2*av.S, 1).Noise(.1).Bed(120, .3)
+	c := New(av.Hz(8000), 2*av.S, 2).Noise(.1).Bed(120, .3)
+	if !reflect.DeepEqual(a.Smpls, b.Smpls) {
+		t.Errorf("same seed got different samples")
+	}
+	if reflect.DeepEqual(a.Smpls, c.Smpls) {
+		t.Errorf("different seed got same samples")
+	}
+}
+
+func TestClap(t *testing.T) {
+	s := New(av.Hz(8000), 2*av.S, 1).Noise(.01).Claps(av.S/2, .8, 0, av.S/4)
+	w := s.Wave(pcm.S16LE, "claps")
+	if i := w.Stat(); i.Count != 16000 || i.Rate != av.Hz(8000) {
+		t.Fatalf("wave info got %+v", i)
+	}
+	smpls, err := w.ReadSamples(0, 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var top []int
+	for i, v := range smpls {
+		if v > 20000 {
+			top = append(top, i)
+		}
+	}
+	if !reflect.DeepEqual(top, []int{4000, 6000}) {
+		t.Errorf("clap peaks got %v", top)
+	}
+}
+
+func TestTone(t *testing.T) {
+	s := New(av.Hz(8000), av.S, 1).Tone(av.S/4, av.S/2, 500, .5)
+	var max float64
+	for i, v := range s.Smpls {
+		if (i < 2000 || i >= 6000) && v != 0 {
+			t.Fatalf("tone outside its span at %d", i)
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max < .49 || max > .5 {
+		t.Errorf("tone max got %g", max)
+	}
+}
+
+func TestSwell(t *testing.T) {
+	s := New(av.Hz(8000), av.S, 1).Swell(av.S/4, av.S/2, 500, .5)
+	var first, last float64
+	for i, v := range s.Smpls {
+		if (i < 2000 || i >= 6000) && v != 0 {
+			t.Fatalf("swell outside its span at %d", i)
+		}
+		if i >= 2000 && i < 2100 {
+			first = math.Max(first, v)
+		}
+		if i >= 5900 && i < 6000 {
+			last = math.Max(last, v)
+		}
+	}
+	if first > .02 || last < .49 {
+		t.Errorf("swell max at start got %g at end %g", first, last)
+	}
+}
+
+func TestMLS(t *testing.T) {
+	for order := 7; order <= 16; order++ {
+		seq := MLS(order)
+		if len(seq) != 1<<order-1 {
+			t.Fatalf("mls %d len got %d", order, len(seq))
+		}
+		// a maximum length sequence has a flat circular autocorrelation of -1
+		for _, lag := range []int{1, 2, 3, len(seq) / 3, len(seq) - 1} {
+			var sum int
+			for i, v := range seq {
+				sum += int(v) * int(seq[(i+lag)%len(seq)])
+			}
+			if sum != -1 {
+				t.Errorf("mls %d autocorrelation at %d got %d", order, lag, sum)
+			}
+		}
+	}
+}
+
+func TestBeep(t *testing.T) {
+	for kind, want := range map[string]int{"chirp": 4000, "mls": 8188} {
+		b, err := ParseBeep(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(b.Template(av.Hz(8000))); got != want {
+			t.Errorf("%s template len got %d want %d", kind, got, want)
+		}
+	}
+	if _, err := ParseBeep("bell"); err == nil {
+		t.Errorf("expected error for unknown beep")
+	}
+}
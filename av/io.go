@@ -13,6 +13,22 @@ func Chunks(chunk, n int) int {
 	return 0
 }
 
+// Min returns the smaller of a and b.
+func Min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Max returns the larger of a and b.
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // ChunkReader is a read seeker wrapper that allows to read byte chunks.
 type ChunkReader struct {
 	io.ReadSeeker
@@ -77,22 +93,33 @@ func Probe(cr ChunkReader, size int, rev bool) *Prober {
 }
 
 // Next returns the n chunks in probe direction or an error.
+// Chunks are aligned to the start for forward and to the end for reverse probes. The probe stops
+// at the end of the data and reads only the remaining bytes.
 func (p *Prober) Next(n int, hand func(int, []byte) error) error {
 	if p.Rev {
-		p.Cur -= n
-		if p.Cur < 0 {
-			n -= p.Cur
-			p.Cur = 0
+		if n > p.Cur {
+			n = p.Cur
 		}
+		p.Cur -= n
+	} else if n > p.Max-p.Cur {
+		n = p.Max - p.Cur
+	}
+	if n <= 0 {
+		return nil
 	}
 	off := p.Cur * p.Chunk
 	if p.Rev {
 		off = p.Size - (p.Max-p.Cur)*p.Chunk
-		if off < 0 {
-			off = 0
-		}
 	}
-	err := p.ReadChunks(off, n*p.Chunk, hand)
+	size := n * p.Chunk
+	if off < 0 {
+		size += off
+		off = 0
+	}
+	if off+size > p.Size {
+		size = p.Size - off
+	}
+	err := p.ReadChunks(off, size, hand)
 	if !p.Rev {
 		p.Cur += n
 	}
@@ -0,0 +1,35 @@
+package av
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestProber(t *testing.T) {
+	data := []byte("0123456789")
+	tests := []struct {
+		rev  bool
+		want []string
+	}{
+		{false, []string{"0:0123", "4:4567", "8:89"}},
+		{true, []string{"6:6789", "2:2345", "0:01"}},
+	}
+	for _, test := range tests {
+		r := NewChunkReader(bytes.NewReader(data), make([]byte, 4))
+		pro := Probe(*r, len(data), test.rev)
+		var got []string
+		for i := 0; i < 5; i++ {
+			err := pro.Next(1, func(off int, buf []byte) error {
+				got = append(got, string(rune('0'+off))+":"+string(buf))
+				return nil
+			})
+			if err != nil {
+				t.Errorf("rev %v next %d: %v", test.rev, i, err)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("rev %v got %v want %v", test.rev, got, test.want)
+		}
+	}
+}
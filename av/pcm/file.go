@@ -1,6 +1,8 @@
 package pcm
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
 )
@@ -28,3 +30,74 @@ func Open(path string, f Format) (*File, error) {
 	count := int(fi.Size() / int64(f.Bytes))
 	return &File{Info{f, path, count}, file}, nil
 }
+
+// Wave is a waveform with random access to its samples.
+// It is implemented by waveform files and in-memory buffers.
+type Wave interface {
+	io.ReadSeekCloser
+	// Stat returns the format, path and sample count of the waveform.
+	Stat() Info
+	// ReadSamples reads up to n samples at sample offset off and appends them to res.
+	ReadSamples(off, n int, res []int16) ([]int16, error)
+}
+
+// Stat returns the format, path and sample count of f.
+func (f *File) Stat() Info { return f.Info }
+
+// ReadSamples reads up to n samples at sample offset off and appends them to res.
+// It returns fewer samples at the end of the file.
+func (f *File) ReadSamples(off, n int, res []int16) ([]int16, error) {
+	return readSamples(f, f.Info, off, n, res)
+}
+
+// Buffer is an in-memory waveform.
+type Buffer struct {
+	Info
+	*bytes.Reader
+}
+
+// NewBuffer returns a new buffer with format f for the waveform bytes b.
+// The path is only used to identify the waveform.
+func NewBuffer(f Format, path string, b []byte) *Buffer {
+	return &Buffer{Info{f, path, len(b) / f.Bytes}, bytes.NewReader(b)}
+}
+
+// Samples returns a new buffer with format f for the 16 bit samples smpls.
+func Samples(f Format, path string, smpls []int16) *Buffer {
+	return NewBuffer(f, path, f.Put(smpls, make([]byte, 0, len(smpls)*f.Bytes)))
+}
+
+// Stat returns the format, path and sample count of b.
+func (b *Buffer) Stat() Info { return b.Info }
+
+// ReadSamples reads up to n samples at sample offset off and appends them to res.
+// It returns fewer samples at the end of the buffer.
+func (b *Buffer) ReadSamples(off, n int, res []int16) ([]int16, error) {
+	return readSamples(b, b.Info, off, n, res)
+}
+
+// Close does nothing.
+func (b *Buffer) Close() error { return nil }
+
+func readSamples(r io.ReadSeeker, i Info, off, n int, res []int16) ([]int16, error) {
+	if off < 0 {
+		n += off
+		off = 0
+	}
+	if rest := i.Count - off; n > rest {
+		n = rest
+	}
+	if n <= 0 {
+		return res, nil
+	}
+	_, err := r.Seek(int64(off*i.Bytes), io.SeekStart)
+	if err != nil {
+		return res, fmt.Errorf("seek %d failed: %w", off, err)
+	}
+	buf := make([]byte, n*i.Bytes)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return res, err
+	}
+	return i.Add(buf, res), nil
+}
@@ -3,6 +3,7 @@ package pcm
 import (
 	"encoding/binary"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/mb0/qnpdub/av"
@@ -22,9 +23,33 @@ type Format struct {
 	av.Rate
 }
 
+// ParseFormat parses a format string like pcm_s8_8000 or pcm_s16le_48000.
+func ParseFormat(str string) (f Format, err error) {
+	idx := strings.LastIndexByte(str, '_')
+	if idx < 0 {
+		return f, fmt.Errorf("invalid pcm format %s", str)
+	}
+	for _, p := range []PCM{S8, U8, S16LE, S16BE, U16LE, U16BE} {
+		if p.String() == str[:idx] {
+			f.PCM = p
+		}
+	}
+	n, err := strconv.Atoi(str[idx+1:])
+	if f.Bytes == 0 || err != nil || n <= 0 {
+		return f, fmt.Errorf("invalid pcm format %s", str)
+	}
+	f.Rate = av.Hz(n)
+	return f, nil
+}
+
 func (f Format) String() string {
 	return fmt.Sprintf("%s_%d", f.PCM, f.Rate.Num)
 }
+func (f Format) MarshalText() ([]byte, error) { return []byte(f.String()), nil }
+func (f *Format) UnmarshalText(b []byte) (err error) {
+	*f, err = ParseFormat(string(b))
+	return err
+}
 
 type PCM struct {
 	Sign  bool
@@ -32,6 +57,14 @@ type PCM struct {
 	binary.ByteOrder
 }
 
+// Add appends the samples in b scaled to 16 bits to res.
+func (pcm PCM) Add(b []byte, res []int16) []int16 {
+	if pcm.Bytes == 2 {
+		return pcm.Add16(b, res)
+	}
+	return pcm.Add8(b, res)
+}
+
 func (pcm PCM) Add8(b []byte, res []int16) []int16 {
 	for o := 0; o < len(b); o++ {
 		s := b[o]
@@ -47,8 +80,7 @@ func (pcm PCM) Add8(b []byte, res []int16) []int16 {
 }
 
 func (pcm PCM) Add16(b []byte, res []int16) []int16 {
-	by := int(pcm.Bytes >> 3)
-	for o := 0; o < len(b); o += by {
+	for o := 0; o+pcm.Bytes <= len(b); o += pcm.Bytes {
 		s := pcm.Uint16(b[o:])
 		var n int16
 		if pcm.Sign {
@@ -61,6 +93,27 @@ func (pcm PCM) Add16(b []byte, res []int16) []int16 {
 	return res
 }
 
+// Put appends the 16 bit samples smpls in this encoding to b. 8 bit encodings drop the low byte.
+func (pcm PCM) Put(smpls []int16, b []byte) []byte {
+	for _, s := range smpls {
+		if pcm.Bytes == 1 {
+			v := byte(s >> 8)
+			if !pcm.Sign {
+				v += 0x80
+			}
+			b = append(b, v)
+			continue
+		}
+		v := uint16(s)
+		if !pcm.Sign {
+			v += 0x8000
+		}
+		b = append(b, 0, 0)
+		pcm.PutUint16(b[len(b)-2:], v)
+	}
+	return b
+}
+
 func (pcm PCM) String() string {
 	var b strings.Builder
 	b.WriteString("pcm_")
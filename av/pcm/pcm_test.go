@@ -1,6 +1,13 @@
 package pcm
 
-import "testing"
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+)
 
 func TestPCMString(t *testing.T) {
 	tests := []struct {
@@ -17,3 +24,71 @@ func TestPCMString(t *testing.T) {
 		}
 	}
 }
+
+func TestPCMAdd(t *testing.T) {
+	tests := []struct {
+		PCM
+		raw  []byte
+		want []int16
+	}{
+		{S8, []byte{0, 1, 0xff, 0x80}, []int16{0, 0x100, -0x100, -0x8000}},
+		{U8, []byte{0x80, 0x81, 0}, []int16{0, 0x100, -0x8000}},
+		{S16LE, []byte{1, 0, 0xff, 0xff, 0, 0x80}, []int16{1, -1, -0x8000}},
+		{S16BE, []byte{0, 1, 0x7f, 0xff}, []int16{1, 0x7fff}},
+	}
+	for _, test := range tests {
+		got := test.Add(test.raw, nil)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s got %v want %v", test.PCM, got, test.want)
+		}
+		if raw := test.Put(test.want, nil); !reflect.DeepEqual(raw, test.raw) {
+			t.Errorf("%s put got %v want %v", test.PCM, raw, test.raw)
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	smpls := []int16{0, 1, -1, 0x7fff, -0x8000}
+	b := Samples(Format{S16LE, av.Hz(8000)}, "mem", smpls)
+	if i := b.Stat(); i.Count != 5 || i.Path != "mem" {
+		t.Errorf("buffer stat got %+v", i)
+	}
+	got, err := b.ReadSamples(-1, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, smpls[:3]) {
+		t.Errorf("buffer read got %v want %v", got, smpls[:3])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, str := range []string{"pcm_s8_8000", "pcm_s16le_48000", "pcm_u16be_44100"} {
+		f, err := ParseFormat(str)
+		if err != nil || f.String() != str {
+			t.Errorf("parse format %s got %s %v", str, f, err)
+		}
+	}
+	for _, str := range []string{"", "pcm_s8", "pcm_s24le_48000", "pcm_s8_x"} {
+		if _, err := ParseFormat(str); err == nil {
+			t.Errorf("parse format %s want error", str)
+		}
+	}
+}
+
+func TestWriteWAV(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteWAV(&b, 48000, []int16{1, -1}); err != nil {
+		t.Fatal(err)
+	}
+	raw := b.Bytes()
+	if len(raw) != 48 || string(raw[:4]) != "RIFF" || string(raw[8:16]) != "WAVEfmt " {
+		t.Fatalf("wav header got %q", raw)
+	}
+	if rate := binary.LittleEndian.Uint32(raw[24:]); rate != 48000 {
+		t.Errorf("wav rate got %d", rate)
+	}
+	if !reflect.DeepEqual(raw[44:], []byte{1, 0, 0xff, 0xff}) {
+		t.Errorf("wav data got %v", raw[44:])
+	}
+}
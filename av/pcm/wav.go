@@ -0,0 +1,27 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// WriteWAV writes the 16 bit samples smpls as mono wav file with sample rate to w.
+func WriteWAV(w io.Writer, rate int, smpls []int16) error {
+	n := len(smpls) * 2
+	b := make([]byte, 44, 44+n)
+	copy(b, "RIFF")
+	binary.LittleEndian.PutUint32(b[4:], uint32(36+n))
+	copy(b[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(b[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(b[20:], 1)  // integer pcm
+	binary.LittleEndian.PutUint16(b[22:], 1)  // mono
+	binary.LittleEndian.PutUint32(b[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(b[28:], uint32(rate*2))
+	binary.LittleEndian.PutUint16(b[32:], 2) // block align
+	binary.LittleEndian.PutUint16(b[34:], 16)
+	copy(b[36:], "data")
+	binary.LittleEndian.PutUint32(b[40:], uint32(n))
+	b = S16LE.Put(smpls, b)
+	_, err := w.Write(b)
+	return err
+}
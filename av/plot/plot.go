@@ -0,0 +1,132 @@
+// Package plot renders waveform envelopes with detected peaks and claps as svg or png images.
+//
+// Each track of a plot shows the min and max envelope of a waveform, the detected peaks, the peaks
+// linked to the clap by matched distances and the clap itself on a time axis.
+package plot
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// Range holds the min and max sample value of an envelope column.
+type Range struct {
+	Min, Max int16
+}
+
+// Track is the data of one waveform in a plot.
+type Track struct {
+	Name  string
+	Start av.Dur   // time at the left edge
+	Env   []Range  // envelope with one range per column
+	Peaks []av.Dur // detected peaks
+	Row   []av.Dur // peaks linked to the clap by matched distances
+	Clap  av.Dur   // chosen clap or a negative value
+}
+
+// Plot is a list of tracks with a common width, track height and time span.
+type Plot struct {
+	Width  int // in pixels
+	Height int // track height in pixels
+	Len    av.Dur
+	Tracks []Track
+}
+
+// MinWidth and MinHeight are the minimum plot width and track height in pixels.
+const MinWidth, MinHeight = 64, 32
+
+// New returns a new plot with width, track height and time span or an error for invalid sizes.
+func New(width, height int, span av.Dur) (*Plot, error) {
+	if width < MinWidth || height < MinHeight || span <= 0 {
+		return nil, fmt.Errorf("invalid plot size %dx%d for %s", width, height, span)
+	}
+	return &Plot{Width: width, Height: height, Len: span}, nil
+}
+
+// Add adds a track for waveform w starting at start with the plot span and returns it.
+func (p *Plot) Add(name string, w pcm.Wave, start av.Dur) (*Track, error) {
+	env, err := Envelope(w, start, p.Len, p.Width)
+	if err != nil {
+		return nil, err
+	}
+	p.Tracks = append(p.Tracks, Track{Name: name, Start: start, Env: env, Clap: -1})
+	return &p.Tracks[len(p.Tracks)-1], nil
+}
+
+// Envelope reads the span of w at start and returns the min and max values for cols columns.
+// Columns outside the waveform are empty.
+func Envelope(w pcm.Wave, start, span av.Dur, cols int) ([]Range, error) {
+	wi := w.Stat()
+	if cols <= 0 || span <= 0 {
+		return nil, fmt.Errorf("invalid envelope size %d for %s", cols, span)
+	}
+	off, n := wi.Beats(start), wi.Beats(span)
+	if start < 0 {
+		off = -wi.Beats(-start)
+	}
+	smpls, err := w.ReadSamples(off, n, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", wi.Path, err)
+	}
+	// samples before the start of w are missing
+	skip := 0
+	if off < 0 {
+		skip = -off
+	}
+	res := make([]Range, cols)
+	for i, s := range smpls {
+		c := (skip + i) * cols / n
+		if c >= cols {
+			break
+		}
+		r := &res[c]
+		if s < r.Min {
+			r.Min = s
+		}
+		if s > r.Max {
+			r.Max = s
+		}
+	}
+	return res, nil
+}
+
+// x returns the column of time t in track tr.
+func (p *Plot) x(tr *Track, t av.Dur) float64 {
+	return float64(t-tr.Start) * float64(p.Width) / float64(p.Len)
+}
+
+// Ticks returns the tick times in track tr for about ten ticks with a round step.
+func (p *Plot) Ticks(tr *Track) []av.Dur {
+	step := av.S / 1000
+	for _, s := range []av.Dur{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000} {
+		if step = s * av.S / 1000; p.Len/step <= 10 {
+			break
+		}
+	}
+	var res []av.Dur
+	t := tr.Start / step * step
+	if t < tr.Start {
+		t += step
+	}
+	for ; t <= tr.Start+p.Len; t += step {
+		res = append(res, t)
+	}
+	return res
+}
+
+var (
+	bgColor   = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	axisColor = color.RGBA{0x88, 0x88, 0x88, 0xff}
+	envColor  = color.RGBA{0x44, 0x66, 0xaa, 0xff}
+	peakColor = color.RGBA{0xdd, 0x33, 0x33, 0xff}
+	rowColor  = color.RGBA{0xee, 0x99, 0x11, 0xff}
+	clapColor = color.RGBA{0x11, 0x99, 0x33, 0xff}
+)
+
+// margin is the height of the time axis below each track.
+const margin = 12
+
+func hex(c color.RGBA) string { return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B) }
@@ -0,0 +1,100 @@
+package plot
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+func testPlot(t *testing.T) *Plot {
+	// two seconds of silence with a clap at one second
+	f := pcm.Format{PCM: pcm.S8, Rate: av.Hz(8000)}
+	b := make([]byte, 16000)
+	for i := 0; i < 40; i++ {
+		b[8000+i] = byte(int8(100 - 2*i))
+	}
+	path := filepath.Join(t.TempDir(), "clap")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := pcm.Open(path, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	p, err := New(200, 50, 2*av.S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := p.Add("clap", w, -av.S/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Clap = av.S
+	tr.Peaks = []av.Dur{av.S, av.S / 2}
+	tr.Row = []av.Dur{av.S / 2}
+	return p
+}
+
+func TestNew(t *testing.T) {
+	for _, size := range [][2]int{{200, 0}, {200, 1}, {0, 50}, {63, 50}} {
+		if _, err := New(size[0], size[1], av.S); err == nil {
+			t.Errorf("new %v want error", size)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	p := testPlot(t)
+	env := p.Tracks[0].Env
+	if len(env) != 200 {
+		t.Fatalf("envelope len got %d want 200", len(env))
+	}
+	// the clap is 1.5s into the track at column 150
+	for i, r := range env {
+		if loud := r.Max > 0; loud != (i == 150) {
+			t.Errorf("envelope column %d got %v", i, r)
+		}
+	}
+}
+
+func TestTicks(t *testing.T) {
+	p := testPlot(t)
+	ts := p.Ticks(&p.Tracks[0])
+	if len(ts) != 10 || ts[0] != -2*av.S/5 || ts[2] != 0 {
+		t.Errorf("ticks got %v", ts)
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	var b strings.Builder
+	if err := testPlot(t).WriteSVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{"<svg", "<path", "clap", "</svg>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("svg missing %q", want)
+		}
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	var b bytes.Buffer
+	if err := testPlot(t).WritePNG(&b); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := img.Bounds().Size(); s.X != 200 || s.Y != 50+margin {
+		t.Errorf("png size got %v", s)
+	}
+}
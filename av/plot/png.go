@@ -0,0 +1,99 @@
+package plot
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// WritePNG writes the plot as png image to w.
+func (p *Plot) WritePNG(w io.Writer) error {
+	return png.Encode(w, p.Image())
+}
+
+// Image renders the plot into a new image. Labels use a small built-in font for times only.
+func (p *Plot) Image() *image.RGBA {
+	th := p.Height + margin
+	img := image.NewRGBA(image.Rect(0, 0, p.Width, th*len(p.Tracks)))
+	fill(img, img.Bounds(), bgColor)
+	for i := range p.Tracks {
+		tr := &p.Tracks[i]
+		top := i * th
+		mid, amp := float64(p.Height)/2, float64(p.Height)/2/math.MaxInt16
+		for x, r := range tr.Env {
+			if r.Min == 0 && r.Max == 0 {
+				continue
+			}
+			vline(img, x, top+int(mid-float64(r.Max)*amp), top+int(mid-float64(r.Min)*amp)+1, envColor)
+		}
+		for _, t := range tr.Peaks {
+			vline(img, int(p.x(tr, t)), top, top+p.Height, peakColor)
+		}
+		if tr.Clap >= 0 {
+			cx := int(p.x(tr, tr.Clap))
+			for j, t := range tr.Row {
+				y := top + 4 + 4*j%(p.Height/2)
+				x := int(p.x(tr, t))
+				hline(img, av.Min(cx, x), av.Max(cx, x), y, rowColor)
+				vline(img, cx, y, y+4, rowColor)
+				vline(img, x, y, y+4, rowColor)
+			}
+			vline(img, cx, top, top+p.Height, clapColor)
+			vline(img, cx+1, top, top+p.Height, clapColor)
+			text(img, cx+3, top+p.Height-8, tr.Clap.String(), clapColor)
+		}
+		hline(img, 0, p.Width, top+p.Height, axisColor)
+		for _, t := range p.Ticks(tr) {
+			x := int(p.x(tr, t))
+			vline(img, x, top+p.Height, top+p.Height+3, axisColor)
+			text(img, x+2, top+p.Height+margin-7, t.String(), axisColor)
+		}
+	}
+	return img
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func vline(img *image.RGBA, x, y0, y1 int, c color.RGBA) {
+	fill(img, image.Rect(x, y0, x+1, y1).Intersect(img.Bounds()), c)
+}
+
+func hline(img *image.RGBA, x0, x1, y int, c color.RGBA) {
+	fill(img, image.Rect(x0, y, x1, y+1).Intersect(img.Bounds()), c)
+}
+
+// glyphs is a 3x5 pixel font for time labels. Each row is three bits from left to right.
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7}, '3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7}, '6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7}, '9': {7, 5, 7, 1, 7}, ':': {0, 2, 0, 2, 0}, '.': {0, 0, 0, 0, 2},
+	'-': {0, 0, 7, 0, 0},
+}
+
+// text draws str with the built-in font at x and top y. Unknown characters are skipped.
+func text(img *image.RGBA, x, y int, str string, c color.RGBA) {
+	for _, r := range str {
+		g, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		for row, bits := range g {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>col) != 0 && image.Pt(x+col, y+row).In(img.Bounds()) {
+					img.SetRGBA(x+col, y+row, c)
+				}
+			}
+		}
+		x += 4
+	}
+}
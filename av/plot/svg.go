@@ -0,0 +1,61 @@
+package plot
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+)
+
+// WriteSVG writes the plot as svg image to w.
+func (p *Plot) WriteSVG(w io.Writer) error {
+	th := p.Height + margin
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="10">`+"\n",
+		p.Width, th*len(p.Tracks))
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", hex(bgColor))
+	for i := range p.Tracks {
+		tr := &p.Tracks[i]
+		fmt.Fprintf(&b, `<g transform="translate(0,%d)">`+"\n", i*th)
+		mid, amp := float64(p.Height)/2, float64(p.Height)/2/math.MaxInt16
+		fmt.Fprintf(&b, `<path stroke="%s" d="`, hex(envColor))
+		for x, r := range tr.Env {
+			if r.Min == 0 && r.Max == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "M%d.5 %.1fV%.1f", x, mid-float64(r.Max)*amp, mid-float64(r.Min)*amp+1)
+		}
+		b.WriteString("\"/>\n")
+		for _, t := range tr.Peaks {
+			fmt.Fprintf(&b, `<line x1="%[1]g" x2="%[1]g" y1="0" y2="%d" stroke="%s"/>`+"\n",
+				p.x(tr, t), p.Height, hex(peakColor))
+		}
+		if tr.Clap >= 0 {
+			cx := p.x(tr, tr.Clap)
+			for j, t := range tr.Row {
+				y := 4 + 4*j%(p.Height/2)
+				fmt.Fprintf(&b, `<polyline points="%g,%d %g,%d %g,%d %g,%d" fill="none" stroke="%s"/>`+"\n",
+					cx, y+4, cx, y, p.x(tr, t), y, p.x(tr, t), y+4, hex(rowColor))
+			}
+			fmt.Fprintf(&b, `<line x1="%[1]g" x2="%[1]g" y1="0" y2="%d" stroke="%s" stroke-width="2"/>`+"\n",
+				cx, p.Height, hex(clapColor))
+			fmt.Fprintf(&b, `<text x="%g" y="%d" fill="%s">clap %s</text>`+"\n",
+				cx+3, p.Height-4, hex(clapColor), tr.Clap)
+		}
+		fmt.Fprintf(&b, `<text x="4" y="12">%s</text>`+"\n", html.EscapeString(tr.Name))
+		fmt.Fprintf(&b, `<line x1="0" x2="%d" y1="%d.5" y2="%[2]d.5" stroke="%s"/>`+"\n",
+			p.Width, p.Height, hex(axisColor))
+		for _, t := range p.Ticks(tr) {
+			x := p.x(tr, t)
+			fmt.Fprintf(&b, `<line x1="%[1]g" x2="%[1]g" y1="%d" y2="%d" stroke="%s"/>`+"\n",
+				x, p.Height, p.Height+3, hex(axisColor))
+			fmt.Fprintf(&b, `<text x="%g" y="%d" fill="%s">%s</text>`+"\n",
+				x+2, p.Height+margin-1, hex(axisColor), t)
+		}
+		b.WriteString("</g>\n")
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,48 @@
+package tempo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+// WriteBlender writes a blender python script to w that adds a timeline marker for each beat at
+// the frame rate fps. Every fourth beat is labeled with the bar number.
+func (g *Grid) WriteBlender(w io.Writer, fps av.Rate) error {
+	if fps.Num <= 0 || fps.Den <= 0 {
+		return fmt.Errorf("invalid frame rate %s", fps)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# beat grid at %.2f bpm\nimport bpy\nmarkers = bpy.context.scene.timeline_markers\n", g.BPM)
+	for i, t := range g.Beats {
+		fmt.Fprintf(&b, "markers.new(%q, frame=%d)\n", label(i), fps.Beats(t))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteArdour writes ardour session locations to w with a marker for each beat at sample rate.
+// The output can be pasted into the Locations element of an ardour session file.
+func (g *Grid) WriteArdour(w io.Writer, rate int) error {
+	if rate <= 0 {
+		return fmt.Errorf("invalid sample rate %d", rate)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!-- beat grid at %.2f bpm -->\n<Locations>\n", g.BPM)
+	r := av.Hz(rate)
+	for i, t := range g.Beats {
+		s := r.Beats(t)
+		fmt.Fprintf(&b, "  <Location name=%q start=\"%d\" end=\"%d\" flags=\"IsMark\"/>\n",
+			label(i), s, s)
+	}
+	b.WriteString("</Locations>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// label returns the marker label for beat i as bar.beat in 4/4 time.
+func label(i int) string {
+	return fmt.Sprintf("%d.%d", i/4+1, i%4+1)
+}
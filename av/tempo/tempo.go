@@ -0,0 +1,185 @@
+// Package tempo estimates the tempo and beat grid of waveforms.
+//
+// The onset strength is the rise of the log energy in short hops. The beat period is the lag with
+// the highest autocorrelation of the onset strength, weighted towards common tempos to avoid
+// octave errors. The phase of the grid is the offset with the highest summed onset strength. The
+// grid is then fitted to the strongest onsets near each beat.
+package tempo
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// Grid is a constant tempo beat grid.
+type Grid struct {
+	BPM   float64  `json:"bpm"`
+	Beats []av.Dur `json:"beats"`
+}
+
+// Shift returns a copy of g with the source start trimmed and the beats scaled by speed. Beats
+// before the trim are dropped. It is used to move the grid of a source into the timeline of a
+// synced output, that trims the source before changing its tempo.
+func (g *Grid) Shift(trim av.Dur, speed float64) *Grid {
+	if speed <= 0 {
+		speed = 1
+	}
+	res := &Grid{BPM: g.BPM * speed}
+	for _, b := range g.Beats {
+		if t := av.Dur(float64(b-trim) / speed); b >= trim {
+			res.Beats = append(res.Beats, t)
+		}
+	}
+	return res
+}
+
+// Estimator is a helper for tempo estimation.
+type Estimator struct {
+	Hop      av.Dur  // onset strength hop duration
+	Min, Max float64 // tempo range in bpm
+	Center   float64 // preferred tempo in bpm
+}
+
+// Default returns a new estimator with 5ms hops for tempos between 60 and 200 bpm.
+func Default() *Estimator {
+	return &Estimator{Hop: 5 * av.S / 1000, Min: 60, Max: 200, Center: 120}
+}
+
+// Estimate reads the waveform w and returns the estimated beat grid or an error.
+func (e *Estimator) Estimate(w pcm.Wave) (*Grid, error) {
+	wi := w.Stat()
+	smpls, err := w.ReadSamples(0, wi.Count, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", wi.Path, err)
+	}
+	return e.EstimateSamples(smpls, wi.Rate)
+}
+
+// EstimateSamples returns the estimated beat grid of smpls at rate or an error.
+func (e *Estimator) EstimateSamples(smpls []int16, rate av.Rate) (*Grid, error) {
+	hop := rate.Beats(e.Hop)
+	if hop < 1 || e.Min <= 0 || e.Max <= e.Min {
+		return nil, fmt.Errorf("invalid tempo estimator %+v", *e)
+	}
+	env := Strength(smpls, hop)
+	hsec := float64(hop) * float64(rate.Den) / float64(rate.Num)
+	lo, hi := int(60/e.Max/hsec), int(math.Ceil(60/e.Min/hsec))
+	if lo < 1 || 2*hi > len(env) {
+		return nil, fmt.Errorf("waveform too short for tempo estimation")
+	}
+	period := e.period(env, lo, hi, hsec)
+	if period == 0 {
+		return nil, fmt.Errorf("no tempo found")
+	}
+	phase, period := fit(env, Phase(env, period), period)
+	g := &Grid{BPM: 60 / (period * hsec)}
+	for t := phase; t < float64(len(env)); t += period {
+		g.Beats = append(g.Beats, av.Dur(t*hsec*float64(av.S)))
+	}
+	return g, nil
+}
+
+// period returns the beat period in hops with the highest weighted autocorrelation of env in the
+// lag range lo to hi, refined by parabolic interpolation.
+func (e *Estimator) period(env []float64, lo, hi int, hsec float64) float64 {
+	ac := make([]float64, hi+2)
+	for lag := lo - 1; lag <= hi+1; lag++ {
+		if lag < 1 {
+			continue
+		}
+		var sum float64
+		for i := lag; i < len(env); i++ {
+			sum += env[i] * env[i-lag]
+		}
+		ac[lag] = sum / float64(len(env)-lag)
+	}
+	best, max := 0, 0.0
+	for lag := lo; lag <= hi; lag++ {
+		// weight by distance to the preferred tempo in octaves
+		oct := math.Log2(60 / (float64(lag) * hsec) / e.Center)
+		if v := ac[lag] * math.Exp(-oct*oct/2); v > max {
+			best, max = lag, v
+		}
+	}
+	if best == 0 {
+		return 0
+	}
+	p := float64(best)
+	if a, b, c := ac[best-1], ac[best], ac[best+1]; a > 0 && a-2*b+c < 0 {
+		p += (a - c) / (2 * (a - 2*b + c))
+	}
+	return p
+}
+
+// Strength returns the onset strength envelope of smpls with the given hop size in samples.
+// It is the positive difference of the log energy of consecutive hops.
+func Strength(smpls []int16, hop int) []float64 {
+	n := len(smpls) / hop
+	res := make([]float64, n)
+	var last float64
+	for i := 0; i < n; i++ {
+		var sum float64
+		for _, s := range smpls[i*hop : (i+1)*hop] {
+			v := float64(s)
+			sum += v * v
+		}
+		cur := math.Log1p(sum / float64(hop))
+		if i > 0 && cur > last {
+			res[i] = cur - last
+		}
+		last = cur
+	}
+	return res
+}
+
+// Phase returns the offset in hops of the grid with period in env that has the highest summed
+// onset strength.
+func Phase(env []float64, period float64) float64 {
+	best, max := 0, -1.0
+	for ph := 0; ph < int(math.Ceil(period)); ph++ {
+		var sum float64
+		for t := float64(ph); int(t+.5) < len(env); t += period {
+			sum += env[int(t+.5)]
+		}
+		if sum > max {
+			best, max = ph, sum
+		}
+	}
+	return float64(best)
+}
+
+// fit snaps the beats of the grid with phase and period to the strongest onset nearby and returns
+// the phase and period of the least squares line through the snapped beats.
+func fit(env []float64, phase, period float64) (float64, float64) {
+	rad := int(period / 8)
+	var n, sk, st, skk, skt float64
+	for k := 0; ; k++ {
+		c := int(phase + float64(k)*period + .5)
+		if c >= len(env) {
+			break
+		}
+		best := -1
+		for i := c - rad; i <= c+rad; i++ {
+			if i >= 0 && i < len(env) && env[i] > 0 && (best < 0 || env[i] > env[best]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		x, y := float64(k), float64(best)
+		n, sk, st, skk, skt = n+1, sk+x, st+y, skk+x*x, skt+x*y
+	}
+	if det := n*skk - sk*sk; n >= 2 && det != 0 {
+		p := (n*skt - sk*st) / det
+		a := (st - p*sk) / n
+		for a-p >= 0 {
+			a -= p
+		}
+		return math.Max(a, 0), p
+	}
+	return phase, period
+}
@@ -0,0 +1,85 @@
+package tempo
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+)
+
+func TestEstimate(t *testing.T) {
+	rate := av.Hz(8000)
+	tests := []struct {
+		bpm   float64
+		first float64
+	}{
+		{120, 0.3},
+		{97, 0.1},
+		{150, 0.25},
+	}
+	for _, test := range tests {
+		smpls := clicks(rate.Num, 30, test.bpm, test.first)
+		g, err := Default().EstimateSamples(smpls, rate)
+		if err != nil {
+			t.Errorf("estimate %g: %v", test.bpm, err)
+			continue
+		}
+		if math.Abs(g.BPM-test.bpm) > .5 {
+			t.Errorf("estimate got %.2f bpm want %g", g.BPM, test.bpm)
+		}
+		if len(g.Beats) == 0 {
+			t.Errorf("estimate %g got no beats", test.bpm)
+			continue
+		}
+		if d := g.Beats[0].Val().Seconds() - test.first; math.Abs(d) > .01 {
+			t.Errorf("estimate %g first beat got %s want %g", test.bpm, g.Beats[0], test.first)
+		}
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	g := (&Grid{BPM: 120, Beats: []av.Dur{0, av.S / 2, av.S, 3 * av.S / 2, 2 * av.S}}).Shift(av.S, 1)
+	if len(g.Beats) != 3 || g.Beats[0] != 0 {
+		t.Fatalf("shift got %v", g.Beats)
+	}
+	// the source is trimmed before the tempo change
+	if got := g.Shift(av.S/2, 2).Beats; len(got) != 2 || got[0] != 0 || got[1] != av.S/4 {
+		t.Errorf("shift with speed got %v", got)
+	}
+	var b strings.Builder
+	if err := g.WriteBlender(&b, av.Rate{Num: 25, Den: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `markers.new("1.3", frame=25)`) {
+		t.Errorf("blender got %s", b.String())
+	}
+	b.Reset()
+	if err := g.WriteArdour(&b, 48000); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<Location name="1.2" start="24000" end="24000" flags="IsMark"/>`) {
+		t.Errorf("ardour got %s", b.String())
+	}
+}
+
+// clicks returns secs of quiet noise with short clicks at bpm starting at first.
+func clicks(rate, secs int, bpm, first float64) []int16 {
+	rnd := rand.New(rand.NewSource(int64(bpm)))
+	res := make([]int16, rate*secs)
+	for i := range res {
+		res[i] = int16(rnd.Intn(201) - 100)
+	}
+	for t := first; t < float64(secs); t += 60 / bpm {
+		off := int(t * float64(rate))
+		for i := 0; i < 200 && off+i < len(res); i++ {
+			v := 20000 * (200 - i) / 200
+			if i%2 == 1 {
+				v = -v
+			}
+			res[off+i] = int16(v)
+		}
+	}
+	return res
+}
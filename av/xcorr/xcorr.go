@@ -0,0 +1,231 @@
+// Package xcorr aligns waveforms without claps by cross-correlation.
+//
+// A coarse search correlates the start of two low rate waveforms using the fft. A fine search then
+// correlates a short window around the coarse lag directly in waveforms with a higher rate.
+package xcorr
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/clap"
+	"github.com/mb0/qnpdub/av/ffm"
+	"github.com/mb0/qnpdub/av/fft"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+var (
+	DefCoarse = pcm.Format{PCM: pcm.S8, Rate: av.Hz(8000)}
+	DefFine   = pcm.Format{PCM: pcm.S16LE, Rate: av.Hz(32000)}
+)
+
+// MinScore is the minimum normalized correlation of the fine search for an alignment.
+const MinScore = 0.2
+
+// Aligner is a helper to align media files by cross-correlation.
+type Aligner struct {
+	Coarse pcm.Format
+	Fine   pcm.Format
+	Max    av.Dur  // max duration read from the start of each waveform for the coarse search or 0 for all
+	Win    av.Dur  // window duration for the fine search
+	Sel    ffm.Sel // audio stream selector
+}
+
+// Default returns a new aligner with a 8khz coarse and 32khz fine format, a coarse search over
+// the first four minutes and a fine search window of ten seconds.
+func Default() *Aligner {
+	return &Aligner{Coarse: DefCoarse, Fine: DefFine, Max: 240 * av.S, Win: 10 * av.S}
+}
+
+// Match aligns the media files at paths to the first one and returns the results as claps.
+// The clap of each file is the position of the common start. The score is the normalized
+// correlation of the fine search.
+func (al *Aligner) Match(paths ...string) ([]clap.Clap, error) {
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("needs at least two waveforms")
+	}
+	cd := clap.New(al.Coarse, 8<<10)
+	fd := clap.New(al.Fine, 8<<10)
+	cd.Sel, fd.Sel = al.Sel, al.Sel
+	cws, err := cd.LoadAll(paths...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(cws)
+	fws, err := fd.LoadAll(paths...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(fws)
+	lags := make([]av.Dur, len(paths))
+	scores := make([]float64, len(paths))
+	scores[0] = 1
+	for i := 1; i < len(paths); i++ {
+		lag, score, err := al.Align(cws[0], cws[i], fws[0], fws[i])
+		if err != nil {
+			return nil, fmt.Errorf("align %q: %w", paths[i], err)
+		}
+		lags[i], scores[i] = lag, score
+	}
+	return claps(lags, scores), nil
+}
+
+// Align returns the lag of b relative to a and the correlation score or an error if the score is
+// below MinScore. The coarse waveforms ca, cb and fine waveforms fa, fb must be of the same media
+// files. A zero Max searches the length of the shorter waveform.
+func (al *Aligner) Align(ca, cb, fa, fb pcm.Wave) (av.Dur, float64, error) {
+	n := av.Min(ca.Stat().Count, cb.Stat().Count)
+	if al.Max > 0 {
+		n = av.Min(n, ca.Stat().Beats(al.Max))
+	}
+	as, err := readFloats(ca, 0, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	bs, err := readFloats(cb, 0, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	lag, _ := Lag(as, bs)
+	// find the center of the overlap in a and convert to the fine rate
+	lo, hi := av.Max(0, -lag), av.Min(ca.Stat().Count, cb.Stat().Count-lag)
+	if hi <= lo {
+		return 0, 0, fmt.Errorf("no overlap at coarse lag %d", lag)
+	}
+	q := float64(al.Fine.Rate.Num*al.Coarse.Rate.Den) / float64(al.Fine.Rate.Den*al.Coarse.Rate.Num)
+	flag := int(math.Round(float64(lag) * q))
+	win := fa.Stat().Beats(al.Win)
+	rad := 2 * int(math.Ceil(q))
+	off := int(float64(lo+hi)/2*q) - win/2
+	if off < 0 {
+		off = 0
+	}
+	aw, err := readFloats(fa, off, win)
+	if err != nil {
+		return 0, 0, err
+	}
+	bw, err := readFloats(fb, off+flag-rad, len(aw)+2*rad)
+	if err != nil {
+		return 0, 0, err
+	}
+	if off+flag-rad < 0 {
+		// pad the missing start of b
+		bw = append(make([]float64, rad-off-flag), bw...)
+	}
+	s, score := Refine(aw, bw, 2*rad)
+	if score < MinScore {
+		return 0, 0, fmt.Errorf("no alignment, best correlation %.3f", score)
+	}
+	return al.Fine.Dur(flag - rad + s), score, nil
+}
+
+// Lag returns the lag of b relative to a with the highest cross-correlation and a score.
+// A positive lag means that the content of a at t is found in b at t+lag.
+// The score is the correlation normalized by the energy of both inputs.
+func Lag(a, b []float64) (lag int, score float64) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, 0
+	}
+	n := fft.Size(len(a) + len(b) - 1)
+	fa := fft.Real(center(a), n, nil)
+	fb := fft.Real(center(b), n, nil)
+	for i := range fa {
+		fa[i] = cmplx.Conj(fa[i]) * fb[i]
+	}
+	fft.IFFT(fa)
+	best := math.Inf(-1)
+	for k := -(len(a) - 1); k < len(b); k++ {
+		v := real(fa[(k+n)%n])
+		if v > best {
+			best, lag = v, k
+		}
+	}
+	if e := math.Sqrt(energy(a) * energy(b)); e > 0 {
+		score = best / e
+	}
+	return lag, score
+}
+
+// Refine returns the shift s in [0, rad] of a window a in b with the highest normalized
+// correlation and that score. Slice b should have rad more samples than a.
+func Refine(a, b []float64, rad int) (s int, score float64) {
+	a = center(a)
+	ea := energy(a)
+	score = math.Inf(-1)
+	for i := 0; i <= rad && i+len(a) <= len(b); i++ {
+		w := center(b[i : i+len(a)])
+		var sum float64
+		for j, v := range a {
+			sum += v * w[j]
+		}
+		if e := math.Sqrt(ea * energy(w)); e > 0 {
+			sum /= e
+		}
+		if sum > score {
+			s, score = i, sum
+		}
+	}
+	return s, score
+}
+
+// claps returns the clap results for lags relative to the first waveform.
+func claps(lags []av.Dur, scores []float64) []clap.Clap {
+	var lo, hi av.Dur
+	for _, lag := range lags {
+		if lag < lo {
+			lo = lag
+		}
+	}
+	res := make([]clap.Clap, len(lags))
+	for i, lag := range lags {
+		res[i] = clap.Clap{Clap: lag - lo, Score: scores[i]}
+		if res[i].Clap > hi {
+			hi = res[i].Clap
+		}
+	}
+	for i := range res {
+		res[i].Off = hi - res[i].Clap
+	}
+	return res
+}
+
+func readFloats(w pcm.Wave, off, n int) ([]float64, error) {
+	smpls, err := w.ReadSamples(off, n, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", w.Stat().Path, err)
+	}
+	res := make([]float64, len(smpls))
+	for i, s := range smpls {
+		res[i] = float64(s)
+	}
+	return res, nil
+}
+
+// center returns a copy of vals with the mean removed.
+func center(vals []float64) []float64 {
+	var mean float64
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+	res := make([]float64, len(vals))
+	for i, v := range vals {
+		res[i] = v - mean
+	}
+	return res
+}
+
+func energy(vals []float64) (sum float64) {
+	for _, v := range vals {
+		sum += v * v
+	}
+	return sum
+}
+
+func closeAll(ws []pcm.Wave) {
+	for _, w := range ws {
+		w.Close()
+	}
+}
@@ -0,0 +1,80 @@
+package xcorr
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/gen"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+func TestLag(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	src := make([]float64, 3000)
+	for i := range src {
+		src[i] = rnd.NormFloat64()
+	}
+	noisy := func(vals []float64) []float64 {
+		res := make([]float64, len(vals))
+		for i, v := range vals {
+			res[i] = v + 0.3*rnd.NormFloat64()
+		}
+		return res
+	}
+	tests := []struct {
+		a, b []float64
+		want int
+	}{
+		{src[500:2500], src[200:2000], 300},
+		{src[200:2000], src[500:2500], -300},
+		{src[0:1000], noisy(src[0:1000]), 0},
+		{noisy(src[1000:]), src[900:2000], 100},
+	}
+	for _, test := range tests {
+		lag, score := Lag(test.a, test.b)
+		if lag != test.want {
+			t.Errorf("lag got %d want %d", lag, test.want)
+		}
+		if score <= 0 || score > 1 {
+			t.Errorf("lag %d score %g out of range", lag, score)
+		}
+		if lag < 0 {
+			continue
+		}
+		s, fine := Refine(test.a[:500], test.b[lag:lag+510], 10)
+		if s != 0 || math.Abs(fine) < 0.9 {
+			t.Errorf("refine lag %d got %d score %g", lag, s, fine)
+		}
+	}
+}
+
+func TestAlign(t *testing.T) {
+	al := Default()
+	// claps at irregular offsets starting at start over quiet noise with seed
+	waves := func(start av.Dur, seed int64) (pcm.Wave, pcm.Wave) {
+		var res [2]pcm.Wave
+		for i, f := range []pcm.Format{al.Coarse, al.Fine} {
+			offs := []av.Dur{0, av.S, 2700 * av.S / 1000, 4100 * av.S / 1000, 7900 * av.S / 1000}
+			s := gen.New(f.Rate, 20*av.S, seed).Noise(.01).Claps(start, .8, offs...)
+			res[i] = s.Wave(f.PCM, "take")
+		}
+		return res[0], res[1]
+	}
+	ca, fa := waves(2*av.S, 1)
+	cb, fb := waves(3500*av.S/1000, 2)
+	lag, score, err := al.Align(ca, cb, fa, fb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1500 * av.S / 1000; lag < want-av.S/1000 || lag > want+av.S/1000 || score < MinScore {
+		t.Errorf("align got %s score %g want %s", lag, score, want)
+	}
+	// noise without claps must not align
+	cn := gen.New(al.Coarse.Rate, 20*av.S, 3).Noise(.3).Wave(al.Coarse.PCM, "noise")
+	fn := gen.New(al.Fine.Rate, 20*av.S, 3).Noise(.3).Wave(al.Fine.PCM, "noise")
+	if lag, score, err := al.Align(ca, cn, fa, fn); err == nil {
+		t.Errorf("align noise got %s score %g want error", lag, score)
+	}
+}
@@ -0,0 +1,231 @@
+// Package zoom generates multi-resolution waveform overviews for display.
+//
+// An overview is a pyramid of levels with min and max value pairs for every scale samples of a
+// waveform, similar to the data files of audiowaveform. Each level is four times coarser than the
+// previous one by default. Overviews are written in a compact binary format or as json.
+//
+// The binary format is little endian and starts with the magic "QNPZ", a uint16 version, a uint16
+// bit depth of 8 or 16, a uint32 sample rate and a uint32 level count. Each level follows with a
+// uint32 scale, a uint32 pair count and the min and max values of each pair as int8 or int16.
+package zoom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+// Scales are the default level scales in samples per pair, from 8ms to 2s at 8khz.
+var Scales = []int{64, 256, 1024, 4096, 16384}
+
+// Level holds min and max value pairs for every scale samples.
+type Level struct {
+	Scale int     `json:"scale"`
+	Data  []int16 `json:"data"` // alternating min and max values
+}
+
+// Len returns the number of pairs in l.
+func (l *Level) Len() int { return len(l.Data) / 2 }
+
+// Overview is a waveform overview with levels ordered from fine to coarse.
+// The values are in the range of the bit depth.
+type Overview struct {
+	Rate   int     `json:"rate"`
+	Bits   int     `json:"bits"`
+	Levels []Level `json:"levels"`
+}
+
+// Level returns the coarsest level with a scale of at most spp samples per pixel or the finest.
+// It returns an error for an overview without levels.
+func (o *Overview) Level(spp int) (*Level, error) {
+	var res *Level
+	for i := range o.Levels {
+		if l := &o.Levels[i]; res == nil || l.Scale <= spp && l.Scale > res.Scale {
+			res = l
+		}
+	}
+	if res == nil {
+		return nil, fmt.Errorf("empty zoom overview")
+	}
+	return res, nil
+}
+
+// Build reads waveform w and returns an overview with levels for the given scales.
+// Each scale must be a multiple of the previous one.
+func Build(w pcm.Wave, scales ...int) (*Overview, error) {
+	if len(scales) == 0 {
+		scales = Scales
+	}
+	for i, s := range scales {
+		if s < 1 || i > 0 && s%scales[i-1] != 0 {
+			return nil, fmt.Errorf("invalid zoom scales %v", scales)
+		}
+	}
+	wi := w.Stat()
+	o := &Overview{Rate: wi.Rate.Num / wi.Rate.Den, Bits: 8 * wi.Bytes}
+	shift := 16 - o.Bits
+	fine := scales[0]
+	l := Level{Scale: fine, Data: make([]int16, 0, 2*(wi.Count+fine-1)/fine)}
+	// read blocks of whole pairs of the finest level
+	block := fine * (1 << 16 / fine)
+	if block == 0 {
+		block = fine
+	}
+	var buf []int16
+	for off := 0; off < wi.Count; off += block {
+		var err error
+		buf, err = w.ReadSamples(off, block, buf[:0])
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", wi.Path, err)
+		}
+		for i := 0; i < len(buf); i += fine {
+			end := i + fine
+			if end > len(buf) {
+				end = len(buf)
+			}
+			min, max := buf[i], buf[i]
+			for _, s := range buf[i+1 : end] {
+				if s < min {
+					min = s
+				} else if s > max {
+					max = s
+				}
+			}
+			l.Data = append(l.Data, min>>shift, max>>shift)
+		}
+	}
+	o.Levels = append(o.Levels, l)
+	for _, s := range scales[1:] {
+		l = merge(l, s)
+		o.Levels = append(o.Levels, l)
+	}
+	return o, nil
+}
+
+// merge returns a coarser level with scale from the pairs of level l.
+func merge(l Level, scale int) Level {
+	n := scale / l.Scale
+	res := Level{Scale: scale, Data: make([]int16, 0, 2*(l.Len()+n-1)/n)}
+	for i := 0; i < len(l.Data); i += 2 * n {
+		end := i + 2*n
+		if end > len(l.Data) {
+			end = len(l.Data)
+		}
+		min, max := l.Data[i], l.Data[i+1]
+		for j := i + 2; j < end; j += 2 {
+			if l.Data[j] < min {
+				min = l.Data[j]
+			}
+			if l.Data[j+1] > max {
+				max = l.Data[j+1]
+			}
+		}
+		res.Data = append(res.Data, min, max)
+	}
+	return res
+}
+
+const magic = "QNPZ"
+
+// WriteTo writes the overview in the binary format to w.
+func (o *Overview) WriteTo(w io.Writer) (int64, error) {
+	if o.Bits != 8 && o.Bits != 16 {
+		return 0, fmt.Errorf("invalid zoom bit depth %d", o.Bits)
+	}
+	b := append([]byte(magic), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(b[4:], 1)
+	binary.LittleEndian.PutUint16(b[6:], uint16(o.Bits))
+	binary.LittleEndian.PutUint32(b[8:], uint32(o.Rate))
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(o.Levels)))
+	for _, l := range o.Levels {
+		b = binary.LittleEndian.AppendUint32(b, uint32(l.Scale))
+		b = binary.LittleEndian.AppendUint32(b, uint32(l.Len()))
+		for _, v := range l.Data {
+			if o.Bits == 8 {
+				b = append(b, byte(int8(v)))
+			} else {
+				b = binary.LittleEndian.AppendUint16(b, uint16(v))
+			}
+		}
+	}
+	m, err := w.Write(b)
+	return int64(m), err
+}
+
+// Read reads an overview in the binary format from r.
+func Read(r io.Reader) (*Overview, error) {
+	var h struct {
+		Magic        [4]byte
+		Version      uint16
+		Bits         uint16
+		Rate, Levels uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("read zoom header: %w", err)
+	}
+	if string(h.Magic[:]) != magic || h.Version != 1 || h.Bits != 8 && h.Bits != 16 {
+		return nil, fmt.Errorf("invalid zoom header")
+	}
+	if h.Levels == 0 {
+		return nil, fmt.Errorf("empty zoom overview")
+	}
+	o := &Overview{Rate: int(h.Rate), Bits: int(h.Bits)}
+	for i := 0; i < int(h.Levels); i++ {
+		var lh struct{ Scale, Len uint32 }
+		if err := binary.Read(r, binary.LittleEndian, &lh); err != nil {
+			return nil, fmt.Errorf("read zoom level: %w", err)
+		}
+		buf := make([]byte, int(lh.Len)*2*o.Bits/8)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("read zoom level: %w", err)
+		}
+		l := Level{Scale: int(lh.Scale), Data: make([]int16, 0, 2*lh.Len)}
+		if o.Bits == 8 {
+			for _, v := range buf {
+				l.Data = append(l.Data, int16(int8(v)))
+			}
+		} else {
+			for j := 0; j < len(buf); j += 2 {
+				l.Data = append(l.Data, int16(binary.LittleEndian.Uint16(buf[j:])))
+			}
+		}
+		o.Levels = append(o.Levels, l)
+	}
+	return o, nil
+}
+
+// Path returns the overview file path for waveform path.
+func Path(wavf string) string { return wavf + ".zoom" }
+
+// Load returns the overview of waveform w stored alongside the waveform file.
+// It generates the overview file with the default scales, if it does not exist.
+// The file is written to a temporary file first and renamed, so readers never see partial files.
+func Load(w *pcm.File) (*Overview, error) {
+	path := Path(w.Path)
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		return Read(bufio.NewReader(f))
+	}
+	o, err := Build(w)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = o.WriteTo(f); err != nil {
+		return nil, fmt.Errorf("write %q: %w", path, err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	return o, os.Rename(f.Name(), path)
+}
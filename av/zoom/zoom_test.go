@@ -0,0 +1,122 @@
+package zoom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mb0/qnpdub/av"
+	"github.com/mb0/qnpdub/av/pcm"
+)
+
+func writeWave(t *testing.T, f pcm.Format, smpls []int16) *pcm.File {
+	var b []byte
+	for _, s := range smpls {
+		if f.Bytes == 1 {
+			b = append(b, byte(int8(s>>8)))
+		} else {
+			b = binary.LittleEndian.AppendUint16(b, uint16(s))
+		}
+	}
+	path := filepath.Join(t.TempDir(), "wave."+f.String())
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := pcm.Open(path, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func TestBuild(t *testing.T) {
+	// a ramp of 10 samples with a spike
+	smpls := []int16{0, 100, 200, -300, 400, 500, 600, 700, 8000, -900}
+	w := writeWave(t, pcm.Format{PCM: pcm.S16LE, Rate: av.Hz(8000)}, smpls)
+	o, err := Build(w, 2, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Level{
+		{2, []int16{0, 100, -300, 200, 400, 500, 600, 700, -900, 8000}},
+		{4, []int16{-300, 200, 400, 700, -900, 8000}},
+		{8, []int16{-300, 700, -900, 8000}},
+	}
+	if o.Rate != 8000 || o.Bits != 16 || !reflect.DeepEqual(o.Levels, want) {
+		t.Errorf("build got %+v", o)
+	}
+	if _, err := Build(w, 2, 3); err == nil {
+		t.Errorf("expected error for scales not multiples")
+	}
+	for spp, scale := range map[int]int{1: 2, 3: 2, 4: 4, 100: 8} {
+		if got, err := o.Level(spp); err != nil || got.Scale != scale {
+			t.Errorf("level for %d got %v %v want %d", spp, got, err, scale)
+		}
+	}
+	if _, err := (&Overview{Rate: 8000, Bits: 8}).Level(4); err == nil {
+		t.Errorf("expected error for empty overview level")
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	smpls := make([]int16, 5000)
+	for i := range smpls {
+		smpls[i] = int16(i*37%20000 - 10000)
+	}
+	for _, f := range []pcm.Format{
+		{PCM: pcm.S8, Rate: av.Hz(8000)},
+		{PCM: pcm.S16LE, Rate: av.Hz(16000)},
+	} {
+		w := writeWave(t, f, smpls)
+		o, err := Build(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if _, err := o.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Read(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, o) {
+			t.Errorf("%s read got %+v want %+v", f, got, o)
+		}
+		if len(o.Levels) != len(Scales) || o.Levels[0].Len() != 79 {
+			t.Errorf("%s levels got %d with %d pairs", f, len(o.Levels), o.Levels[0].Len())
+		}
+	}
+	var b bytes.Buffer
+	if _, err := (&Overview{Rate: 8000, Bits: 8}).WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(&b); err == nil {
+		t.Errorf("expected error for empty overview")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	w := writeWave(t, pcm.Format{PCM: pcm.S8, Rate: av.Hz(8000)}, make([]int16, 1000))
+	o, err := Load(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(Path(w.Path)); err != nil {
+		t.Fatal(err)
+	}
+	if tmps, _ := filepath.Glob(Path(w.Path) + ".*.tmp"); len(tmps) != 0 {
+		t.Errorf("load left temporary files %v", tmps)
+	}
+	got, err := Load(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, o) {
+		t.Errorf("load got %+v want %+v", got, o)
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mb0/qnpdub/av/clap"
+	"github.com/mb0/qnpdub/av/zoom"
 )
 
 func main() {
@@ -22,6 +29,12 @@ func main() {
 		err = doClap(args)
 	case "sync":
 		err = doSync(args)
+	case "split":
+		err = doSplit(args)
+	case "tempo":
+		err = doTempo(args)
+	case "beep":
+		err = doBeep(args)
 	case "web":
 		err = doWeb(args)
 	case "help":
@@ -53,12 +66,50 @@ Media flags
    -fps=0
        Sets the output frame rate, use 30 or 30/1, and 30000/1001 instead of 29.97
 
+   -tempo=0
+       Sets the audio speed factor to compensate clock drift. Sync sets it automatically if the
+       claps at both ends matched.
+
    -dim=0
        Sets the output dimensions, use 720:-2 to scale width to 720px preserving input ratio.
 
    -yes=false
        Override existing output files.
 
+   -vsel=0
+   -asel=0
+       Selects the video and audio stream used for probing, clap detection and concatenation.
+       Use a stream index among streams of that kind, lang=<language>, title=<title> tags, an
+       audio layout=<channel layout> or a comma separated combination like title=mic,1.
+
+   -arate=0 -afmt= -layout=
+   -pix= -sar=0:0
+       Sets the output audio sample rate, sample format and channel layout, and the video pixel
+       format and sample aspect ratio. Concatenated inputs are converted to match. Unset values
+       default to the format of the first input.
+
+   -trans=cut
+       Sets the default transition between clips. Use a xfade transition name and duration like
+       fade:0.5 or dissolve:1, the audio is cross faded for the same duration.
+
+   -join=<n>=<trans>
+       Overrides the transition between clip n and n+1. Can be repeated or separated by comma.
+
+   -title= -artist= -dubber= -instrument= -license=
+       Sets the credits for the original work and the dub shown in title cards and overlays.
+
+   -intro=0 -outro=0 -lower=0 -fade=0
+       Sets the durations of the intro and outro title cards, the lower-third overlay at the start
+       and the fade in and out of all titles. The cards are added to the output duration.
+
+   -font= -fontsize=48 -fontcolor=white -pos=left
+       Sets the title font file or pattern, size and color, and the lower-third position: left,
+       center, right, top-left, top or top-right.
+
+   -meta=<key>=<value>
+       Adds a metadata tag to the output. Can be repeated. The title, artist, dubber and license
+       credits are added as tags, and each concatenated clip and the clap point as chapters.
+
 
 Media commands
 
@@ -68,19 +119,149 @@ Media commands
 
    clap <paths>
         Detects a matching end-clap in media files and prints the result as json.
-        Uses fps flag.
+        The result contains the matched distance count, peak prominence and runner-up candidates.
+        Ambiguous matches are printed and reported as error, and refused by sync.
+        Uses fps flag and the clap flags.
+        -plot=
+            Writes a plot of the waveforms around the claps to an svg or png file. The plot
+            shows the envelope, the peaks used by the match, peaks linked to the clap by matched
+            distances or pattern offsets and the chosen clap, also for ambiguous matches.
+            An existing plot file is only overridden with the yes flag.
+        -plotw=1200
+            Plot width in pixels, at least 64.
+        -ploth=160
+            Plot track height in pixels, at least 32.
 
    sync <out> <paths>
    	Detects a matching end-clap in the last video and audio and concatenates to output.
 	The output uses starts with the first audio stream up to the detected clap.
-        Uses fps, scale flags.
+	With a start-clap the output ends with the shorter of video and audio.
+	Logs the vod, aod and tempo offsets used for the output.
+        Uses fps, scale flags and the clap flags.
+        -method=clap
+            Selects the sync method: clap matches claps, xcorr aligns the waveforms by
+            cross-correlation for recordings without claps, flash finds a visual marker like a
+            flash, a phone-screen slate or a hand closing in front of the lens in the video and
+            matches it with the best ranked audio peak for videos with muted or unusable audio.
+        -xmax=240s
+            Duration read from the start of both files for the xcorr search or 0 for the whole
+            shorter file. Alignments below a normalized correlation of 0.2 are refused.
+
+   split <path> [<dir>]
+        Scans a long session recording for clap markers after silence and prints the proposed
+        takes as json. Each take is written to <dir>/<name>.takeNN.<ext>, by default next to the
+        recording. The streams are copied without re-encoding, so cuts start at a key frame.
+        Uses the clap flags.
+        -silence=20
+            Silence level as divisor of the loudest level.
+        -gap=2
+            Min silence duration before a clap marker.
+        -pad=0.5
+            Padding before the clap and after the last sound of a take.
+        -dry=false
+            Only prints the proposed takes.
+        -reenc=false
+            Re-encodes the takes with the default codecs for frame accurate cuts.
+
+   tempo <path>
+        Estimates the tempo and beat grid of a song and prints it. The beats are shifted into the
+        output timeline by the aod and tempo flags, use the offsets logged by sync.
+        -format=json
+            Selects the output: json, a blender python script adding timeline markers at the fps
+            flag, or ardour session locations.
+        -rate=48000
+            Sets the sample rate of the ardour session.
+
+   beep <out.wav>
+        Writes a sync beep as mono wav file to play from a phone at the start or end of a take.
+        An existing file is only overridden with the yes flag.
+        Use the -beep clap flag with the same kind to find it with sample accuracy, even under
+        loud playing.
+        -kind=chirp
+            Selects the beep: chirp is a half second sweep from 500hz to 3.5khz, mls is a one
+            second noise burst of a maximum length sequence.
+        -rate=48000
+            Sets the sample rate of the wav file.
+        -pad=1
+            Silence before and after the beep.
+        -level=0.5
+            Beep level between 0 and 1.
+
+
+Clap flags
+
+   -at=end
+       Selects the clap marker: end scans backward for an end-clap, start scans forward for a
+       start-clap or count-in, both or auto scans both ends and uses the better match.
+       With matching claps at both ends the clock drift is reported in ppm.
+
+   -preset=default
+       Loads the detection config from a preset default, quiet or drums, or from a json file.
+       Use it before the other clap flags, because it replaces the whole config.
+
+   -threshold=3
+       Peak threshold in standard deviations from the moving mean.
+
+   -influence=0
+       Influence of detected peaks on the moving mean between 0 and 1.
 
+   -lag=0
+       Moving mean window in samples, zero uses a quarter of the chunk.
+
+   -peaks=8
+       Number of loud peaks detected and matched for each waveform.
+
+   -window=5s
+       Search step duration when scanning for loud peaks.
+
+   -loud=3
+       Ignores peaks quieter than the loudest peak divided by this ratio. Peaks are ranked by
+       the energy of their signal event divided by the attack time, so sharp claps rank above
+       louder swelling sounds.
+
+   -wavf=pcm_s8_8000
+       Waveform format used for detection.
+
+   -pattern= -tol=0.030
+       Matches a clap rhythm like 0,0.5,1 for three claps half a second apart instead of comparing
+       peak distances. Only full pattern matches within the timing tolerance are reported.
+
+   -refine=0
+       Refines the clap to the onset in a window of this duration decoded at the source sample
+       rate, use 0.02 for 20ms. The coarse detection only has the precision of the waveform.
+
+   -onset=zscore
+       Selects the onset detector: zscore finds peaks in the sample amplitudes, flux finds
+       broadband transients in the spectrum and is better at claps over loud music.
+
+   -filter=
+       Applies a comma separated filter chain before onset detection: dc blocks the dc offset,
+       hp:<freq>, lp:<freq> and bp:<freq> with an optional :<quality> are biquad filters and
+       env:<attack>:<release> is an envelope follower. The clap chain is dc,hp:1000 and removes
+       low drum thumps. The drums preset uses the clap chain. Filtering is off by default,
+       because the default detection is tuned on unfiltered waveforms and a high-pass weakens
+       soft claps in quiet rooms without drums.
+
+   -beep=
+       Matches a sync beep chirp or mls written by the beep command instead of claps. The beep is
+       found by a matched filter in the first or last minute, and refined at the source sample
+       rate with the -refine window.
+
+   -ref=chain
+       Selects how multiple files are matched: chain matches neighbours sorted by peak count, auto
+       or a file number starting at 1 matches every file independently to that reference. The
+       reference mode reports the lag matrix of all pairs and flags pairs that disagree with the
+       lags via the reference by more than the -tol duration.
 
 
 Other commands
 
    web
        Starts a local webserver with some information.
+       Serves waveform overviews of media files below the working directory at
+       /zoom?path=<path>&scale=<samples per pixel>&fmt=json|dat for zoomable displays.
+       The overview is generated alongside the clap waveform as <wavf>.zoom with min and max
+       pairs for 64, 256, 1024, 4096 and 16384 samples.
        -addr=localhost:8403
            Configures the server address.
 
@@ -96,9 +277,54 @@ func doWeb(args []string) error {
 	flags.Parse(args)
 
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static"))))
+	http.HandleFunc("/zoom", serveZoom)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "web/index.html")
 	})
 	fmt.Printf("Starting mb0/qnpdub server at http://%s", addr)
 	return http.ListenAndServe(addr, nil)
 }
+
+// serveZoom serves the waveform overview of a media file below the working directory.
+// The path parameter selects the media file, the optional scale parameter the level for that many
+// samples per pixel and the fmt parameter dat the binary format instead of json.
+func serveZoom(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	path := filepath.Clean(q.Get("path"))
+	if q.Get("path") == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "..") {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	d := clap.Default()
+	wf, err := d.Load(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer wf.Close()
+	o, err := zoom.Load(wf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if s := q.Get("scale"); s != "" {
+		spp, err := strconv.Atoi(s)
+		if err != nil || spp < 1 {
+			http.Error(w, "invalid scale", http.StatusBadRequest)
+			return
+		}
+		l, err := o.Level(spp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		o.Levels = []zoom.Level{*l}
+	}
+	if q.Get("fmt") == "dat" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		o.WriteTo(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
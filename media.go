@@ -2,13 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mb0/qnpdub/av"
 	"github.com/mb0/qnpdub/av/clap"
 	"github.com/mb0/qnpdub/av/ffm"
+	"github.com/mb0/qnpdub/av/flash"
+	"github.com/mb0/qnpdub/av/gen"
+	"github.com/mb0/qnpdub/av/pcm"
+	"github.com/mb0/qnpdub/av/tempo"
+	"github.com/mb0/qnpdub/av/xcorr"
 )
 
 func doCat(args []string) error {
@@ -18,25 +27,78 @@ func doCat(args []string) error {
 	if len(as) == 0 {
 		as = vs
 	}
-	return o.Concat(out, ffm.Paths(vs), ffm.Paths(as))
+	return o.Concat(out, vs, as)
 }
 
 func doClap(args []string) error {
-	o, paths := opts(args)
 	d := clap.Default()
+	co := &clapOpts{Width: 1200, Height: 160}
+	o, paths := opts(args, d, co)
+	d.Sel = o.ASel
 	ws, err := d.LoadAll(paths...)
 	if err != nil {
 		return err
 	}
 	offs, err := d.Match(o.Fps, ws...)
+	var amb *clap.AmbiguousError
+	if err != nil && !errors.As(err, &amb) {
+		return err
+	}
+	if co.Plot != "" {
+		if perr := co.plot(o, d, ws, offs); perr != nil {
+			return perr
+		}
+	}
+	// print ambiguous results for inspection and return the error
+	if jerr := json.NewEncoder(os.Stdout).Encode(offs); jerr != nil {
+		return jerr
+	}
+	return err
+}
+
+// clapOpts holds the clap specific flags.
+type clapOpts struct {
+	Plot          string
+	Width, Height int
+}
+
+func (co *clapOpts) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&co.Plot, "plot", co.Plot, "write a waveform plot to svg or png file")
+	fs.IntVar(&co.Width, "plotw", co.Width, "plot width in pixels")
+	fs.IntVar(&co.Height, "ploth", co.Height, "plot track height in pixels")
+}
+
+// plot writes a plot of the waveforms around the claps to the plot file by extension.
+// An existing plot file is only overridden with the yes flag.
+func (co *clapOpts) plot(o *ffm.Opts, d *clap.Detector, ws []pcm.Wave, res []clap.Clap) error {
+	ext := strings.ToLower(filepath.Ext(co.Plot))
+	if ext != ".svg" && ext != ".png" {
+		return fmt.Errorf("unknown plot format %q", ext)
+	}
+	p, err := d.Plot(ws, res, co.Width, co.Height)
 	if err != nil {
 		return err
 	}
-	return json.NewEncoder(os.Stdout).Encode(offs)
+	f, err := create(o, co.Plot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if ext == ".svg" {
+		err = p.WriteSVG(f)
+	} else {
+		err = p.WritePNG(f)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 func doSync(args []string) error {
-	o, args := opts(args)
+	d := clap.Default()
+	so := &syncOpts{Method: "clap", XMax: xcorr.Default().Max}
+	o, args := opts(args, d, so)
 	out := args[0]
 	vs, as := probe(o, args[1:])
 	if len(as) < 1 || len(vs) < 1 {
@@ -44,16 +106,20 @@ func doSync(args []string) error {
 	}
 	// last video and audio index and offset duration
 	vl, al := len(vs)-1, len(as)-1
-	vlo, alo := sumDur(vs[:vl]), sumDur(as[:al])
+	// transitions overlap clips and move the start of the last clips
+	vlo, alo := ffm.SumDur(vs[:vl])-o.Overlap(vl), ffm.SumDur(as[:al])-o.Overlap(al)
 	// detect clap in the last video and last audio file
-	d := clap.Default()
-	ws, err := d.LoadAll(vs[vl].Path, as[al].Path)
+	claps, err := so.match(o, d, vs[vl].Path, as[al].Path)
 	if err != nil {
 		return err
 	}
-	claps, err := d.Match(o.Fps, ws...)
-	if err != nil {
-		return err
+	// correct the audio clock drift measured from claps at both ends
+	if claps[1].Drift != 0 && o.Tempo == 0 {
+		o.Tempo = claps[1].Speed()
+	}
+	tempo := o.Tempo
+	if tempo <= 0 {
+		tempo = 1
 	}
 	// get clap in total offset
 	vc := vlo + claps[0].Clap
@@ -61,26 +127,236 @@ func doSync(args []string) error {
 	// we want to start if we have both video and audio
 	// as we usually start recording audio synced to a song
 	// TODO calulate offsets respecting opts offs
-	if diff := vc - ac; diff >= 0 {
+	if diff := vc - scale(ac, 1/tempo); diff >= 0 {
 		fr := vs[0].Video().Rate("r_frame_rate")
 		o.Vod = diff.Sync(fr)
 	} else {
-		o.Aod = -diff
+		o.Aod = scale(-diff, tempo)
+	}
+	o.Clap = vc - o.Vod
+	o.Dur = o.Clap
+	if so.Method != "clap" || claps[0].At == clap.Start {
+		// we want to end when either video or audio ends
+		o.Dur = o.Length(vs, o.Vod)
+		if ad := scale(o.Length(as, o.Aod), 1/tempo); ad < o.Dur {
+			o.Dur = ad
+		}
+	}
+	log.Printf("sync offsets -vod=%s -aod=%s -tempo=%g", o.Vod.Secs(), o.Aod.Secs(), tempo)
+	if _, ok := o.Meta.Tags["comment"]; !ok {
+		o.Meta.Tags["comment"] = syncComment(o, vs[vl], as[al])
 	}
-	o.Dur = vc - o.Vod
-	return o.Concat(out, ffm.Paths(vs), ffm.Paths(as))
+	return o.Concat(out, vs, as)
 }
 
-func sumDur(nfos []*ffm.Info) (sum av.Dur) {
-	for _, nfo := range nfos {
-		sum += nfo.Format.Dur("duration")
+// syncOpts holds the sync specific flags.
+type syncOpts struct {
+	Method string
+	XMax   av.Dur
+}
+
+func (so *syncOpts) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&so.Method, "method", so.Method, "sync method clap, flash or xcorr")
+	fs.TextVar(&so.XMax, "xmax", so.XMax, "xcorr search duration at the start or 0 for all")
+}
+
+// match returns the claps for the video and audio path using the sync method.
+func (so *syncOpts) match(o *ffm.Opts, d *clap.Detector, vpath, apath string) ([]clap.Clap, error) {
+	switch so.Method {
+	case "clap":
+		d.Sel = o.ASel
+		ws, err := d.LoadAll(vpath, apath)
+		if err != nil {
+			return nil, err
+		}
+		return d.Match(o.Fps, ws...)
+	case "flash":
+		// the video clap is found visually and matched with the loudest audio peak
+		fd := flash.Default()
+		fd.At, fd.Sel = d.At, o.VSel
+		fs, err := fd.Load(vpath)
+		if err != nil {
+			return nil, err
+		}
+		vc, err := fd.Detect(fs)
+		if err != nil {
+			return nil, fmt.Errorf("flash in %q: %w", vpath, err)
+		}
+		d.Sel = o.ASel
+		w, err := d.Load(apath)
+		if err != nil {
+			return nil, err
+		}
+		ac, err := d.Loudest(w)
+		if err != nil {
+			return nil, err
+		}
+		return []clap.Clap{vc, ac}, nil
+	case "xcorr":
+		al := xcorr.Default()
+		al.Sel, al.Max = o.ASel, so.XMax
+		return al.Match(vpath, apath)
+	}
+	return nil, fmt.Errorf("invalid sync method %q", so.Method)
+}
+
+func doSplit(args []string) error {
+	d := clap.Default()
+	sc := clap.DefScan
+	so := &splitOpts{}
+	o, args := opts(args, d, &sc, so)
+	if len(args) < 1 {
+		return fmt.Errorf("split needs a media file")
+	}
+	path, dir := args[0], filepath.Dir(args[0])
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	d.Sel = o.ASel
+	w, err := d.Load(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	takes, err := d.Scan(w, sc)
+	if err != nil {
+		return err
 	}
-	return sum
+	if err = json.NewEncoder(os.Stdout).Encode(takes); err != nil || so.Dry {
+		return err
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	for i, t := range takes {
+		out := filepath.Join(dir, fmt.Sprintf("%s.take%02d%s", base, i+1, ext))
+		err = o.Cut(path, out, t.Start, t.Dur(), !so.Reenc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitOpts holds the split specific flags.
+type splitOpts struct {
+	Dry   bool
+	Reenc bool
+}
+
+func (so *splitOpts) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&so.Dry, "dry", so.Dry, "only print the proposed takes")
+	fs.BoolVar(&so.Reenc, "reenc", so.Reenc, "re-encode takes for frame accurate cuts")
+}
+
+func doTempo(args []string) error {
+	to := &tempoOpts{Format: "json", Rate: 48000}
+	o, args := opts(args, to)
+	if len(args) != 1 {
+		return fmt.Errorf("tempo needs one media file")
+	}
+	d := clap.Default()
+	d.Sel = o.ASel
+	w, err := d.Load(args[0])
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	g, err := tempo.Default().Estimate(w)
+	if err != nil {
+		return err
+	}
+	// move the grid into the output timeline of a sync with these offsets
+	g = g.Shift(o.Aod, o.Tempo)
+	switch to.Format {
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(g)
+	case "blender":
+		if o.Fps.Num == 0 {
+			return fmt.Errorf("blender markers need the fps flag")
+		}
+		return g.WriteBlender(os.Stdout, o.Fps)
+	case "ardour":
+		return g.WriteArdour(os.Stdout, to.Rate)
+	}
+	return fmt.Errorf("invalid tempo format %q", to.Format)
+}
+
+// tempoOpts holds the tempo specific flags.
+type tempoOpts struct {
+	Format string
+	Rate   int
+}
+
+func (to *tempoOpts) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&to.Format, "format", to.Format, "tempo output format json, blender or ardour")
+	fs.IntVar(&to.Rate, "rate", to.Rate, "ardour session sample rate")
+}
+
+func doBeep(args []string) error {
+	bo := &beepOpts{Kind: "chirp", Rate: 48000, Pad: av.S, Level: .5}
+	o, args := opts(args, bo)
+	if len(args) != 1 {
+		return fmt.Errorf("beep needs an output file")
+	}
+	b, err := gen.ParseBeep(bo.Kind)
+	if err != nil {
+		return err
+	}
+	if bo.Rate <= 0 || bo.Pad < 0 || bo.Level <= 0 || bo.Level > 1 {
+		return fmt.Errorf("invalid beep options %+v", *bo)
+	}
+	rate := av.Hz(bo.Rate)
+	s := gen.New(rate, 2*bo.Pad+rate.Dur(len(b.Template(rate))), 0)
+	s.Beep(b, bo.Pad, bo.Level)
+	f, err := create(o, args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = pcm.WriteWAV(f, bo.Rate, s.Samples()); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// beepOpts holds the beep specific flags.
+type beepOpts struct {
+	Kind  string
+	Rate  int
+	Pad   av.Dur
+	Level float64
 }
 
-func opts(args []string) (*ffm.Opts, []string) {
+func (bo *beepOpts) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&bo.Kind, "kind", bo.Kind, "beep kind chirp or mls")
+	fs.IntVar(&bo.Rate, "rate", bo.Rate, "beep sample rate")
+	fs.TextVar(&bo.Pad, "pad", bo.Pad, "beep silence before and after")
+	fs.Float64Var(&bo.Level, "level", bo.Level, "beep level between 0 and 1")
+}
+
+// syncComment returns a comment with the sync offsets and original work for the output metadata.
+func syncComment(o *ffm.Opts, v, a *ffm.Info) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "synced %s and %s with video offset %s and audio offset %s",
+		v.File(), a.File(), o.Vod.Secs(), o.Aod.Secs())
+	if w := o.Text.Work(); w != "" {
+		fmt.Fprintf(&b, "; original work %s", w)
+	}
+	return b.String()
+}
+
+// scale returns d scaled by factor f.
+func scale(d av.Dur, f float64) av.Dur { return av.Dur(float64(d) * f) }
+
+// flagger is implemented by types that add their own flags to a flag set.
+type flagger interface{ AddFlags(*flag.FlagSet) }
+
+func opts(args []string, more ...flagger) (*ffm.Opts, []string) {
 	o := ffm.Def()
 	flags := o.Flags()
+	for _, m := range more {
+		m.AddFlags(flags)
+	}
 	err := flags.Parse(args)
 	if err != nil {
 		log.Fatalf("invalid flag: %v", err)
@@ -88,6 +364,20 @@ func opts(args []string) (*ffm.Opts, []string) {
 	return o, flags.Args()
 }
 
+// create creates the output file at path. It refuses to override an existing file unless the
+// yes flag is set.
+func create(o *ffm.Opts, path string) (*os.File, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !o.Yes {
+		flag |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w, use -yes to override", err)
+	}
+	return f, err
+}
+
 func probe(o *ffm.Opts, paths []string) (vs, as []*ffm.Info) {
 	nfos, err := o.ProbeAll(paths...)
 	if err != nil {
@@ -5,6 +5,7 @@
 package peak
 
 import (
+	"fmt"
 	"math"
 
 	"golang.org/x/exp/constraints"
@@ -14,19 +15,65 @@ type Num interface {
 	constraints.Integer | constraints.Float
 }
 
-// Peaks contains a summery, offset and signals for a chunk of values.
+// Peaks contains a summery, offset, signals and events for a chunk of values.
 type Peaks[N Num] struct {
 	Sum[N]
-	Off  int
-	Sigs []Sig[N]
+	Off    int
+	Sigs   []Sig[N]
+	Events []Event[N]
 }
 
-// Signal holds the index and value of a peak.
+// Signal holds the absolute index and value of a peak.
 type Sig[N Num] struct {
 	Idx int
 	Val N
 }
 
+// Event is a group of signals with at most a gap of values in between.
+// Events do not span chunks.
+type Event[N Num] struct {
+	Start, End int     // absolute index of the first and last signal
+	Peak       int     // absolute index of the peak signal
+	Val        N       // peak value
+	Dev        float64 // deviation of the peak value from the moving mean
+	Pol        int     // polarity of the peak, 1 above and -1 below the moving mean
+	Energy     float64 // sum of the squared deviations of all signals from the moving mean
+	Len        int     // number of signals
+}
+
+// Near returns whether idx is at most gap values from a non-empty event e.
+func (e *Event[N]) Near(idx, gap int) bool {
+	return e.Len > 0 && idx >= e.Start-gap && idx <= e.End+gap
+}
+
+// Add adds the signal at idx with value val and deviation dev from the moving mean to e.
+func (e *Event[N]) Add(idx int, val N, dev float64) {
+	if e.Len == 0 {
+		e.Start, e.End = idx, idx
+	} else if idx < e.Start {
+		e.Start = idx
+	} else if idx > e.End {
+		e.End = idx
+	}
+	if e.Len == 0 || math.Abs(dev) > math.Abs(e.Dev) {
+		e.Peak, e.Val, e.Dev, e.Pol = idx, val, dev, 1
+		if dev < 0 {
+			e.Pol = -1
+		}
+	}
+	e.Energy += dev * dev
+	e.Len++
+}
+
+// NextEvent returns the last event of r if idx is near it or appends and returns a new event.
+func (r *Peaks[N]) NextEvent(idx, gap int) *Event[N] {
+	if n := len(r.Events) - 1; n >= 0 && r.Events[n].Near(idx, gap) {
+		return &r.Events[n]
+	}
+	r.Events = append(r.Events, Event[N]{})
+	return &r.Events[len(r.Events)-1]
+}
+
 // Summery holds aggregate detauls for a chunk of values.
 type Sum[N Num] struct {
 	Mean, Vari float64
@@ -64,13 +111,21 @@ func (sum Sum[N]) Stdd() float64 {
 	return math.Sqrt(sum.Vari)
 }
 
+// Float is the type of the moving statistics of a detector.
+type Float interface {
+	~float32 | ~float64
+}
+
 // Detector is a helper to detect peaks in value sequences.
+// It keeps the moving statistics in float64.
 type Detector[N Num] struct {
-	conf
-	Sum[N]
-	win []float64
-	val float64
-	idx int
+	detector[N, float64]
+}
+
+// Detector32 is a peak detector that keeps the moving statistics in float32.
+// It halves the window memory, but the results may differ from Detector due to rounding.
+type Detector32[N Num] struct {
+	detector[N, float32]
 }
 
 // New returns a new peak detector with influence, threshold, lag and warmup.
@@ -79,68 +134,176 @@ type Detector[N Num] struct {
 // Lag is the number of signals in the window of the moving mean.
 // Warmup is the number of signals before peaks are detected.
 func New[N Num](infl, trsh float64, lag, warm int) *Detector[N] {
+	return &Detector[N]{newDetector[N, float64](infl, trsh, lag, warm)}
+}
+
+// New32 returns a new float32 peak detector with influence, threshold, lag and warmup, see New.
+func New32[N Num](infl, trsh float64, lag, warm int) *Detector32[N] {
+	return &Detector32[N]{newDetector[N, float32](infl, trsh, lag, warm)}
+}
+
+// State is the explicit and serializable state of a peak detector. It can be saved and restored
+// to resume a scan or to continue it in another detector.
+type State[F Float] struct {
+	Win  []F  `json:"win"`  // window of the moving mean
+	Pos  int  `json:"pos"`  // next window position
+	Mean F    `json:"mean"` // moving mean
+	Vari F    `json:"vari"` // moving variance
+	Last F    `json:"last"` // last value with influence applied
+	Next int  `json:"next"` // absolute index of the next value
+	Seen int  `json:"seen"` // number of values seen
+	Rev  bool `json:"rev"`  // whether values are fed backward
+}
+
+type detector[N Num, F Float] struct {
+	conf
+	Sum[N]
+	st State[F]
+}
+
+func newDetector[N Num, F Float](infl, trsh float64, lag, warm int) detector[N, F] {
 	if warm < lag {
 		warm = lag
 	}
-	return &Detector[N]{conf: conf{infl: infl, trsh: trsh, lag: lag, warm: warm}}
+	return detector[N, F]{conf: conf{infl: infl, trsh: trsh, lag: lag, warm: warm}}
+}
+
+// Feed finds and returns peaks for a chunk of values at an offset, see FeedTo.
+func (d *detector[N, F]) Feed(off int, vals ...N) Peaks[N] {
+	var r Peaks[N]
+	d.FeedTo(&r, off, vals)
+	return r
 }
 
-// Feed finds and returns peaks for a chunk of values at an offset.
-// The offset is returned in the result and used for min and max offsets.
-func (d *Detector[N]) Feed(off int, vals ...N) Peaks[N] {
-	if d.win == nil {
-		d.win = make([]float64, d.lag)
+// FeedTo finds the peaks for a chunk of values at the absolute offset off and stores them in r.
+// The offset is returned in the result and used for signal, min and max offsets.
+// Backward detectors feed the chunk from the last to the first value and return signals in that
+// order, so the window continues across chunks fed in reverse order.
+// A chunk that does not continue at the next offset in the feed direction restarts the moving
+// window, so the statistics never mix values of unrelated chunks. The summary is kept.
+// The signals reuse the capacity of r.Sigs, so a result buffer can be fed repeatedly without
+// allocations. The moving statistics are kept in local variables while the chunk is processed.
+func (d *detector[N, F]) FeedTo(r *Peaks[N], off int, vals []N) {
+	s := &d.st
+	if s.Win == nil {
+		s.Win = make([]F, d.lag)
+	}
+	if next := off; len(vals) > 0 && s.Seen > 0 {
+		if s.Rev {
+			next += len(vals) - 1
+		}
+		if next != s.Next {
+			d.restart()
+		}
+	}
+	*r = Peaks[N]{Off: off, Sigs: r.Sigs[:0], Events: r.Events[:0]}
+	infl, trsh2 := F(d.infl), F(d.trsh*d.trsh)
+	lag := F(d.lag)
+	mean, vari, last := s.Mean, s.Vari, s.Last
+	win, pos, seen := s.Win, s.Pos, s.Seen
+	i, step, end := 0, 1, len(vals)
+	if s.Rev {
+		i, step, end = len(vals)-1, -1, -1
 	}
-	r := Peaks[N]{Off: off}
-	var v, w float64
-	for i, val := range vals {
-		wi := d.idx % d.lag
-		v = float64(val)
-		if d.Vari != 0 {
-			if math.Abs(v-d.Mean) > d.trsh*d.Stdd() {
-				r.Sigs = append(r.Sigs, Sig[N]{Idx: d.idx, Val: val})
-				v = d.infl*v + (1-d.infl)*d.val
+	for ; i != end; i += step {
+		val := vals[i]
+		v := F(val)
+		if vari != 0 {
+			// compare squared to avoid the square root of the variance for each value
+			if dv := v - mean; dv*dv > trsh2*vari && vari > 0 {
+				r.Sigs = append(r.Sigs, Sig[N]{Idx: off + i, Val: val})
+				if d.gap > 0 {
+					r.NextEvent(off+i, d.gap).Add(off+i, val, float64(dv))
+				}
+				v = infl*v + (1-infl)*last
 			}
-			w = d.win[wi]
-			p := (v - w) / float64(d.lag)
-			d.Vari += (v + w - 2*d.Mean - p) * p
-			d.Mean += p
+			w := win[pos]
+			p := (v - w) / lag
+			vari += (v + w - 2*mean - p) * p
+			mean += p
 		}
 		r.Show(off+i, val)
-		d.win[wi] = v
-		d.val = v
-		d.idx++
-		if d.idx%d.warm == 0 && d.Vari == 0 {
-			warmup(d)
+		win[pos] = v
+		last = v
+		if pos++; pos == len(win) {
+			pos = 0
+		}
+		if seen++; vari == 0 && seen%d.warm == 0 {
+			mean, vari = warmup(win)
 		}
 	}
+	s.Mean, s.Vari, s.Last = mean, vari, last
+	s.Pos, s.Seen, s.Next = pos, seen, off+i
+	d.Mean, d.Vari = float64(mean), float64(vari)
 	d.Merge(r.Sum)
 	r.Mean, r.Vari = d.Mean, d.Vari
-	return r
 }
 
-// Reset reverts the detector to its initial condition.
-func (d *Detector[N]) Reset() { *d = Detector[N]{conf: d.conf} }
+// Reset reverts the detector to its initial condition in the given direction.
+// The window buffer is kept and cleared.
+func (d *detector[N, F]) Reset(rev bool) {
+	win := d.st.Win
+	for i := range win {
+		win[i] = 0
+	}
+	*d = detector[N, F]{conf: d.conf, st: State[F]{Win: win, Rev: rev}}
+}
+
+// restart clears the moving window and statistics but keeps the direction and summary.
+func (d *detector[N, F]) restart() {
+	win := d.st.Win
+	for i := range win {
+		win[i] = 0
+	}
+	d.st = State[F]{Win: win, Rev: d.st.Rev}
+}
+
+// State returns a copy of the detector state.
+func (d *detector[N, F]) State() State[F] {
+	s := d.st
+	s.Win = append([]F(nil), s.Win...)
+	return s
+}
+
+// SetState restores a copy of state s or returns an error if the window does not fit the lag.
+// The summary of all fed values is not part of the state and is reset.
+func (d *detector[N, F]) SetState(s State[F]) error {
+	if s.Win != nil && len(s.Win) != d.lag || s.Pos < 0 || s.Pos >= d.lag {
+		return fmt.Errorf("invalid peak state for lag %d", d.lag)
+	}
+	win := d.st.Win
+	if s.Win != nil {
+		win = append(win[:0], s.Win...)
+	} else {
+		win = nil
+	}
+	*d = detector[N, F]{conf: d.conf, st: s}
+	d.st.Win = win
+	d.Mean, d.Vari = float64(s.Mean), float64(s.Vari)
+	return nil
+}
 
-// ResetIdx resets the detector index only.
-func (d *Detector[N]) ResetIdx() { d.idx = 0 }
+// Group enables events grouping signals with at most gap values in between.
+// A gap of zero disables events.
+func (d *detector[N, F]) Group(gap int) { d.gap = gap }
 
 type conf struct {
 	infl, trsh float64
 	lag, warm  int
+	gap        int
 }
 
-func warmup[N Num](d *Detector[N]) {
-	var pre, sqs float64
-	for i, val := range d.win {
+// warmup returns the mean and variance of the values in win.
+func warmup[F Float](win []F) (F, F) {
+	var pre, sqs F
+	for i, val := range win {
 		if i == 0 {
 			pre = val
 			continue
 		}
-		mean := pre + (val-pre)/float64(i+1)
+		mean := pre + (val-pre)/F(i+1)
 		sqs += (val - pre) * (val - mean)
 		pre = mean
 	}
-	d.Mean = pre
-	d.Vari = sqs / float64(len(d.win))
+	return pre, sqs / F(len(win))
 }
@@ -1,6 +1,9 @@
 package peak
 
 import (
+	"encoding/json"
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -45,3 +48,201 @@ func TestDetector(t *testing.T) {
 		}
 	}
 }
+
+func TestDetector32(t *testing.T) {
+	data := noise(1<<14, 1)
+	d, d32 := New[int16](.1, 5, 256, 512), New32[int16](.1, 5, 256, 512)
+	got, want := d32.Feed(0, data...), d.Feed(0, data...)
+	if len(got.Sigs) != len(want.Sigs) {
+		t.Fatalf("signal len got %d want %d", len(got.Sigs), len(want.Sigs))
+	}
+	for i, s := range got.Sigs {
+		if s != want.Sigs[i] {
+			t.Errorf("signal %d got %v want %v", i, s, want.Sigs[i])
+		}
+	}
+}
+
+func TestFeedTo(t *testing.T) {
+	data := noise(1<<14, 2)
+	want := New[int16](0, 5, 256, 512).Feed(0, data...)
+	d := New[int16](0, 5, 256, 512)
+	var r Peaks[int16]
+	var sigs []Sig[int16]
+	for off := 0; off < len(data); off += 1024 {
+		d.FeedTo(&r, off, data[off:off+1024])
+		sigs = append(sigs, r.Sigs...)
+	}
+	if len(sigs) != len(want.Sigs) {
+		t.Fatalf("signal len got %d want %d", len(sigs), len(want.Sigs))
+	}
+	for i, s := range sigs {
+		if s != want.Sigs[i] {
+			t.Errorf("signal %d got %v want %v", i, s, want.Sigs[i])
+		}
+	}
+	if d.Max != want.Max || d.Mao != want.Mao {
+		t.Errorf("summary got %v want %v", d.Sum, want.Sum)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		d.Reset(false)
+		d.FeedTo(&r, 0, data)
+	})
+	if allocs != 0 {
+		t.Errorf("feed to allocates %g times", allocs)
+	}
+}
+
+func TestState(t *testing.T) {
+	data := noise(1<<14, 4)
+	want := New[int16](.1, 5, 256, 512).Feed(0, data...)
+	// feed the first half, save and restore the state in a new detector and feed the rest
+	d := New[int16](.1, 5, 256, 512)
+	sigs := d.Feed(0, data[:5000]...).Sigs
+	b, err := json.Marshal(d.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st State[float64]
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Next != 5000 || st.Seen != 5000 {
+		t.Errorf("state got next %d seen %d", st.Next, st.Seen)
+	}
+	d = New[int16](.1, 5, 256, 512)
+	if err := d.SetState(st); err != nil {
+		t.Fatal(err)
+	}
+	sigs = append(sigs, d.Feed(st.Next, data[st.Next:]...).Sigs...)
+	if !reflect.DeepEqual(sigs, want.Sigs) {
+		t.Errorf("resumed signals got %v want %v", sigs, want.Sigs)
+	}
+	// a chunk that does not continue at the next offset restarts the window
+	if err := d.SetState(st); err != nil {
+		t.Fatal(err)
+	}
+	got := d.Feed(6000, data[6000:]...)
+	fresh := New[int16](.1, 5, 256, 512).Feed(6000, data[6000:]...)
+	if !reflect.DeepEqual(got.Sigs, fresh.Sigs) || d.State().Seen != len(data)-6000 {
+		t.Errorf("skipped chunk got seen %d signals %v want %v", d.State().Seen, got.Sigs, fresh.Sigs)
+	}
+	if err := d.SetState(State[float64]{Win: make([]float64, 3)}); err == nil {
+		t.Errorf("expected error for invalid window")
+	}
+}
+
+func TestBackward(t *testing.T) {
+	data := noise(1<<14, 5)
+	rev := make([]int16, len(data))
+	for i, v := range data {
+		rev[len(rev)-1-i] = v
+	}
+	want := New[int16](.1, 5, 256, 512).Feed(0, rev...)
+	// feed the chunks from last to first
+	d := New[int16](.1, 5, 256, 512)
+	d.Reset(true)
+	var sigs []Sig[int16]
+	for off := len(data) - 1024; off >= 0; off -= 1024 {
+		pk := d.Feed(off, data[off:off+1024]...)
+		sigs = append(sigs, pk.Sigs...)
+	}
+	if d.State().Next != -1 {
+		t.Errorf("backward next got %d want -1", d.State().Next)
+	}
+	// feeding the last chunk again restarts the window
+	off := len(data) - 1024
+	got := d.Feed(off, data[off:]...)
+	fresh := New[int16](.1, 5, 256, 512)
+	fresh.Reset(true)
+	if st := d.State(); st.Seen != 1024 || st.Next != off-1 ||
+		!reflect.DeepEqual(got.Sigs, fresh.Feed(off, data[off:]...).Sigs) {
+		t.Errorf("backward restart got seen %d next %d signals %v", st.Seen, st.Next, got.Sigs)
+	}
+	if len(sigs) != len(want.Sigs) {
+		t.Fatalf("signal len got %d want %d", len(sigs), len(want.Sigs))
+	}
+	for i, s := range sigs {
+		w := want.Sigs[i]
+		if s.Idx != len(data)-1-w.Idx || s.Val != w.Val {
+			t.Errorf("signal %d got %v want %v", i, s, w)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	// quiet noise with an oscillating click and a single negative spike
+	data := make([]int16, 4000)
+	for i := range data {
+		data[i] = int16(i%3 - 1)
+	}
+	for i := 0; i < 20; i++ {
+		v := int16(1000 - 40*i)
+		if i%2 == 1 {
+			v = -v
+		}
+		data[2000+i] = v
+	}
+	data[3000] = -3000
+	d := New[int16](0, 5, 256, 512)
+	d.Group(4)
+	r := d.Feed(0, data...)
+	if len(r.Events) != 2 {
+		t.Fatalf("events got %d want 2: %+v", len(r.Events), r.Events)
+	}
+	click, spike := r.Events[0], r.Events[1]
+	if click.Start != 2000 || click.Peak != 2000 || click.Val != 1000 || click.Pol != 1 || click.Len < 10 {
+		t.Errorf("click event got %+v", click)
+	}
+	if spike.Start != 3000 || spike.End != 3000 || spike.Pol != -1 || spike.Len != 1 {
+		t.Errorf("spike event got %+v", spike)
+	}
+	if math.Abs(spike.Energy-spike.Dev*spike.Dev) > 1e-6 || click.Energy <= click.Dev*click.Dev {
+		t.Errorf("event energy got %g and %g", click.Energy, spike.Energy)
+	}
+}
+
+// noise returns n samples of pseudo random noise with a loud click every 1000 samples.
+func noise(n int, seed uint32) []int16 {
+	res := make([]int16, n)
+	x := seed
+	for i := range res {
+		x = x*1664525 + 1013904223
+		res[i] = int16(x>>24) - 128
+		if i%1000 == 999 {
+			res[i] = 20000
+		}
+	}
+	return res
+}
+
+func BenchmarkFeed(b *testing.B) {
+	data := noise(1<<16, 3)
+	d := New[int16](.1, 5, 2048, 4096)
+	b.SetBytes(int64(len(data) * 2))
+	for i := 0; i < b.N; i++ {
+		d.Feed(0, data...)
+	}
+}
+
+func BenchmarkFeedTo(b *testing.B) {
+	data := noise(1<<16, 3)
+	d := New[int16](.1, 5, 2048, 4096)
+	var r Peaks[int16]
+	b.SetBytes(int64(len(data) * 2))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.FeedTo(&r, 0, data)
+	}
+}
+
+func BenchmarkFeedTo32(b *testing.B) {
+	data := noise(1<<16, 3)
+	d := New32[int16](.1, 5, 2048, 4096)
+	var r Peaks[int16]
+	b.SetBytes(int64(len(data) * 2))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.FeedTo(&r, 0, data)
+	}
+}